@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/mitchellh/cli"
+	"github.com/pkg/errors"
+	"github.com/schattian/bencher/internal/bencher"
+)
+
+const (
+	defaultRounds     = 5
+	defaultRoundCount = 1
+)
+
+type abCmd struct {
+	docker *client.Client
+	pull   string // policy of the images of the rounds, as on bencher run
+}
+
+func prepareAb() (cli.Command, error) {
+	docker, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return nil, err
+	}
+	pruneContainers(context.Background(), docker)
+	return &abCmd{docker: docker}, nil
+}
+
+func (cmd *abCmd) Run(args []string) int {
+	fs := newFlagSet("ab")
+	rounds := fs.Int("rounds", defaultRounds, "")
+	count := fs.Int("count", defaultRoundCount, "")
+	f := newRunFlags()
+	fs.StringVar(&f.pull, "pull", f.pull, "")
+	args, ok := parseInterspersed(fs, args)
+	if !ok {
+		return cli.RunResultHelp
+	}
+	if *rounds <= 0 {
+		fmt.Printf("err invalid --rounds value %d", *rounds)
+		return 1
+	}
+	if *count <= 0 {
+		fmt.Printf("err invalid --count value %d", *count)
+		return 1
+	}
+	if len(args) != 2 {
+		return cli.RunResultHelp
+	}
+	root, _ := getModPath() // the user config applies anyway
+	err := f.loadConfig(root, givenFlags(fs))
+	if err != nil {
+		fmt.Printf("err loadConfig: %v", err)
+		return 1
+	}
+	cmd.pull = f.pull
+	err = checkPull(cmd.pull)
+	if err != nil {
+		fmt.Printf("err %v", err)
+		return 1
+	}
+	db, err := initDB()
+	if err != nil {
+		fmt.Printf("err initDB: %v", err)
+		return 1
+	}
+	jobs, err := getJobs(db, args...)
+	db.Close()
+	if err != nil {
+		fmt.Printf("err getJobs: %v", err)
+		return 1
+	}
+	if len(jobs) != 2 {
+		fmt.Printf("err both versions must be completed to be interleaved")
+		return 1
+	}
+
+	ctx := context.Background()
+	roundVersions, err := cmd.schedRounds(ctx, jobs[0], jobs[1], *rounds, *count)
+	if err != nil {
+		fmt.Printf("err schedRounds: %v", err)
+		return 1
+	}
+	err = waitJobs(ctx, cmd.docker, roundVersions)
+	if err != nil {
+		fmt.Printf("err waitJobs: %v", err)
+		return 1
+	}
+
+	db, err = initDB()
+	if err != nil {
+		fmt.Printf("err initDB: %v", err)
+		return 1
+	}
+	defer db.Close()
+	err = (&cmpCmd{docker: cmd.docker}).cmp(db, args...)
+	if err != nil {
+		fmt.Printf("err cmp: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// schedRounds creates a runner for each round and schedules them alternating the versions (A,B,B,A,A,B,...). Each
+// round takes the given count of samples
+func (cmd *abCmd) schedRounds(ctx context.Context, a, b *bencher.Job, rounds, count int) ([]string, error) {
+	var roundVersions []string
+	for i := 0; i < rounds; i++ {
+		pair := []*bencher.Job{a, b}
+		if i%2 == 1 {
+			pair = []*bencher.Job{b, a}
+		}
+		for _, parent := range pair {
+			round := *parent // the same spec, sidecars and gates included
+			round.Version, round.Parent = fmt.Sprintf("%s.ab%d", parent.Version, i), parent.Version
+			round.Stdout, round.Stderr, round.Reason, round.SetupOutput = "", "", "", ""
+			round.CompileTime, round.HostLoad = 0, nil
+			round.Priority = a.Priority // a shared one, otherwise the queue would group the rounds by version
+			if len(round.Cmd) == 0 {
+				round.Cmd = defaultCmd
+			}
+			round.Cmd = withCount(round.Cmd, count)
+			for _, image := range append([]string{round.Image}, sidecarImages(round.Sidecars)...) {
+				err := ensureImage(ctx, cmd.docker, image, cmd.pull)
+				if err != nil {
+					return nil, errors.Wrapf(err, "ensureImage[%s]", round.Version)
+				}
+			}
+			err := createJob(ctx, cmd.docker, &round, filepath.Join(bencher.HostVersionsPath, parent.Snapshot()))
+			if err != nil {
+				return nil, errors.Wrapf(err, "createJob[%s]", round.Version)
+			}
+			roundVersions = append(roundVersions, round.Version)
+		}
+	}
+	_, serverCpuset, err := pinCpus(ctx, cmd.docker, a.Limits.Cpuset)
+	if err != nil {
+		return nil, errors.Wrap(err, "pinCpus")
+	}
+	err = runServerCmd(ctx, cmd.docker, append([]string{"sched"}, roundVersions...), serverCpuset, cmd.pull, os.Getenv("BENCHER_DEBUG") != "")
+	if err != nil {
+		return nil, errors.Wrap(err, "runServerCmd")
+	}
+	return roundVersions, nil
+}
+
+// withCount replaces the -count of the given go test command, so it takes the given count of samples. Other commands
+// are kept as they are
+func withCount(cmd []string, count int) []string {
+	if len(cmd) < 2 || cmd[0] != "go" || cmd[1] != "test" {
+		return cmd
+	}
+	counted := []string{"go", "test", fmt.Sprintf("-count=%d", count)}
+	for i := 2; i < len(cmd); i++ {
+		arg := cmd[i]
+		if arg == "-args" || arg == "--args" { // the rest is given to the test binary
+			return append(counted, cmd[i:]...)
+		}
+		switch {
+		case arg == "-count" || arg == "--count":
+			i++ // skip its value
+		case strings.HasPrefix(arg, "-count=") || strings.HasPrefix(arg, "--count="):
+		default:
+			counted = append(counted, arg)
+		}
+	}
+	return counted
+}
+
+// waitJobs blocks until all the given versions were completed by the scheduler. It fails if some of them are left
+// pending once no server is running
+func waitJobs(ctx context.Context, docker *client.Client, versions []string) error {
+	idle := 0
+	for {
+		db, err := initDB()
+		if err != nil {
+			return errors.Wrap(err, "initDB")
+		}
+		var left int
+		for _, version := range versions {
+			if isPending(db, version) {
+				left++
+			}
+		}
+		db.Close()
+		if left == 0 {
+			fmt.Println()
+			return nil
+		}
+		running, err := isServerRunning(ctx, docker)
+		if err != nil {
+			return errors.Wrap(err, "isServerRunning")
+		}
+		idle++
+		if running {
+			idle = 0
+		}
+		if idle > 1 { // checked twice, as a server may be about to start them
+			return fmt.Errorf("the server exited leaving %d versions pending, rerun with BENCHER_DEBUG=1 to see its output", left)
+		}
+		fmt.Printf("\rwaiting for jobs to complete (%d/%d)", len(versions)-left, len(versions))
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func isServerRunning(ctx context.Context, docker *client.Client) (bool, error) {
+	args := filters.NewArgs(
+		filters.Arg("label", fmt.Sprintf("%s=server", bencher.ContainersLabel)),
+		filters.Arg("status", "running"),
+	)
+	containers, err := docker.ContainerList(ctx, types.ContainerListOptions{Filters: args})
+	return len(containers) > 0, err
+}
+
+func (cmd *abCmd) Synopsis() string {
+	return `interleave runs of two versions for a fair comparison`
+}
+
+func (cmd *abCmd) Help() string {
+	return fmt.Sprintf(`Usage: bencher ab [--rounds] [--count] [--pull] <version1> <version2>
+
+Alternate runs of two completed versions (A,B,B,A,...) so host drift affects both evenly, and compare them once all the rounds finish.
+Each round reruns the version command and appends its samples to the version output. [--rounds] defaults to %d
+Each round takes [--count] (default: %d) samples of each benchmark, replacing the -count of the go test command, so the rounds are short and alternate often
+All the rounds are queued with the priority of <version1>, so they keep alternating
+[--pull] sets when the images of the rounds and the server are pulled, as on "bencher run" (default: the pull of the config files, or missing)
+The rounds that fail (e.g: timed-out) are listed apart on "bencher get <version>", leaving the version status and samples as they were`, defaultRounds, defaultRoundCount)
+}
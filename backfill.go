@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/client"
+	"github.com/mitchellh/cli"
+	"github.com/pkg/errors"
+)
+
+type backfillCmd struct {
+	docker *client.Client
+}
+
+func prepareBackfill() (cli.Command, error) {
+	docker, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return nil, err
+	}
+	pruneContainers(context.Background(), docker)
+	return &backfillCmd{docker: docker}, nil
+}
+
+func (cmd *backfillCmd) Run(args []string) int {
+	fs := newFlagSet("backfill")
+	step := fs.Int("every", 1, "")
+	profile := fs.String("p", "", "")
+	fs.StringVar(profile, "profile", "", "")
+	if !parseFlags(fs, args) {
+		return cli.RunResultHelp
+	}
+	if *step <= 0 {
+		fmt.Printf("err invalid --every value %d", *step)
+		return 1
+	}
+	args = fs.Args() // the range, followed by the go test command as given
+	if len(args) == 0 || !strings.Contains(args[0], "..") {
+		return cli.RunResultHelp
+	}
+	err := cmd.backfill(context.Background(), args[0], *step, *profile, args[1:])
+	if err != nil {
+		fmt.Printf("err %v", err)
+		return 1
+	}
+	return 0
+}
+
+func (cmd *backfillCmd) backfill(ctx context.Context, commitRange string, step int, profile string, forward []string) error {
+	root, err := getModPath()
+	if err != nil {
+		return errors.Wrap(err, "getModPath")
+	}
+	bounds := strings.SplitN(commitRange, "..", 2)
+	from, _, err := resolveCommit(root, bounds[0])
+	if err != nil {
+		return errors.Wrap(err, "resolveCommit")
+	}
+	to, _, err := resolveCommit(root, bounds[1])
+	if err != nil {
+		return errors.Wrap(err, "resolveCommit")
+	}
+	revs, err := git(root, "rev-list", "--first-parent", "--reverse", fmt.Sprintf("%s..%s", from, to))
+	if err != nil {
+		return err
+	}
+	commits := strings.Fields(revs)
+	var shas []string
+	for i := len(commits) - 1; i >= 0; i -= step { // counted from the newest, so the end of the range is always taken
+		shas = append([]string{commits[i]}, shas...)
+	}
+	if len(shas) == 0 {
+		return fmt.Errorf("no commits in %s", commitRange)
+	}
+	fmt.Printf("backfilling %d commits\n", len(shas))
+
+	run, tmpl, err := configuredRun(ctx, cmd.docker, profile)
+	if err != nil {
+		return errors.Wrap(err, "configuredRun")
+	}
+	versions, created, err := run.prepareCommits(ctx, tmpl, "backfill", shas, forward)
+	if err != nil {
+		return errors.Wrap(err, "prepareCommits")
+	}
+	if len(created) > 0 {
+		err = runServerCmd(ctx, cmd.docker, append([]string{"sched"}, created...), run.serverCpuset, run.pull, os.Getenv("BENCHER_DEBUG") != "")
+		if err != nil {
+			return errors.Wrap(err, "runServerCmd")
+		}
+	}
+	fmt.Printf("scheduled %d versions (%d already existed), from %s to %s\n", len(created), len(versions)-len(created), versions[0], versions[len(versions)-1])
+	return nil
+}
+
+func (cmd *backfillCmd) Synopsis() string {
+	return `schedule a version for each commit of a range`
+}
+
+func (cmd *backfillCmd) Help() string {
+	return `Usage: bencher backfill [--every] [-p] <from>..<to> [go test command]
+
+Schedule a version for each first-parent commit of the range, exported from the local git history and named "backfill-<commit>-<hash of the command, env and image>".
+The versions of a previous backfill with the same command are reused unless they failed, in which case they are recreated.
+The flags must be given before the range, the [go test command] after it is forwarded as it is.
+If [--every] N is given, only one of each N commits is taken (counting from <to>, which is always taken).
+The versions keep the commit time and subject, use "bencher get --by-commit" or "bencher cmp --by-commit" to sort them in commit order.
+They're created with the defaults of the config files (see "bencher run"), using the profile given by [-p] if any (its matrix is ignored)`
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/client"
+	"github.com/mitchellh/cli"
+	"github.com/pkg/errors"
+	"golang.org/x/perf/benchstat"
+)
+
+// benchstatMetrics maps the units of go test to the metrics of the benchstat tables
+var benchstatMetrics = map[string]string{
+	"ns/op":     "time/op",
+	"B/op":      "alloc/op",
+	"allocs/op": "allocs/op",
+	"MB/s":      "speed",
+}
+
+type bisectCmd struct {
+	docker *client.Client
+
+	bench     string
+	metric    string
+	threshold float64 // in percent
+	count     int
+	profile   string // of the config files, the versions are created with
+}
+
+func prepareBisect() (cli.Command, error) {
+	docker, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return nil, err
+	}
+	pruneContainers(context.Background(), docker)
+	return &bisectCmd{docker: docker}, nil
+}
+
+func (cmd *bisectCmd) Run(args []string) int {
+	fs := newFlagSet("bisect")
+	good := fs.String("good", "", "")
+	bad := fs.String("bad", "HEAD", "")
+	fs.StringVar(&cmd.bench, "bench", ".", "")
+	metric := fs.String("metric", "ns/op", "")
+	threshold := fs.String("threshold", "5%", "")
+	fs.IntVar(&cmd.count, "count", 10, "")
+	fs.StringVar(&cmd.profile, "p", "", "")
+	fs.StringVar(&cmd.profile, "profile", "", "")
+	args, ok := parseInterspersed(fs, args)
+	if !ok || len(args) != 0 || *good == "" {
+		return cli.RunResultHelp
+	}
+	cmd.metric = *metric
+	if m, ok := benchstatMetrics[*metric]; ok {
+		cmd.metric = m
+	}
+	var err error
+	cmd.threshold, err = strconv.ParseFloat(strings.TrimSuffix(*threshold, "%"), 64)
+	if err != nil || cmd.threshold < 0 {
+		fmt.Printf("err invalid --threshold value %q", *threshold)
+		return 1
+	}
+	if cmd.count < 2 {
+		fmt.Printf("err invalid --count value %d, at least 2 samples are needed", cmd.count)
+		return 1
+	}
+
+	err = cmd.bisect(context.Background(), *good, *bad)
+	if err != nil {
+		fmt.Printf("err %v", err)
+		return 1
+	}
+	return 0
+}
+
+func (cmd *bisectCmd) bisect(ctx context.Context, good, bad string) error {
+	root, err := getModPath()
+	if err != nil {
+		return errors.Wrap(err, "getModPath")
+	}
+	goodSha, _, err := resolveCommit(root, good)
+	if err != nil {
+		return errors.Wrap(err, "resolveCommit")
+	}
+	badSha, _, err := resolveCommit(root, bad)
+	if err != nil {
+		return errors.Wrap(err, "resolveCommit")
+	}
+	if goodSha == badSha {
+		return fmt.Errorf("%s and %s are the same commit, there's nothing to bisect", good, bad)
+	}
+	ok, err := isAncestor(root, goodSha, badSha)
+	if err != nil {
+		return errors.Wrap(err, "isAncestor")
+	}
+	if !ok {
+		return fmt.Errorf("%s is not an ancestor of %s", good, bad)
+	}
+	revs, err := git(root, "rev-list", "--first-parent", "--reverse", fmt.Sprintf("%s..%s", goodSha, badSha))
+	if err != nil {
+		return err
+	}
+	commits := strings.Fields(revs) // never empty, as bad is a descendant of good
+
+	versions, err := cmd.schedCommits(ctx, goodSha, badSha)
+	if err != nil {
+		return errors.Wrap(err, "schedCommits")
+	}
+	baseline := versions[0]
+	tested := map[string]string{badSha: versions[1]} // versions of the commits
+	regressed, err := cmd.isRegression(baseline, versions[1])
+	if err != nil {
+		return errors.Wrap(err, "isRegression")
+	}
+	if !regressed {
+		fmt.Printf("%s doesn't regress %s by more than %g%% compared to %s\n", bad, cmd.metric, cmd.threshold, good)
+		return cmd.printCmp(baseline, versions[1])
+	}
+
+	firstBad := len(commits) - 1 // indexes of commits, where -1 is the good one
+	lastGood := -1
+	for firstBad-lastGood > 1 {
+		mid := (lastGood + firstBad) / 2
+		fmt.Printf("bisecting: %d commits left to test\n", firstBad-lastGood-1)
+		versions, err := cmd.schedCommits(ctx, commits[mid])
+		if err != nil {
+			return errors.Wrap(err, "schedCommits")
+		}
+		tested[commits[mid]] = versions[0]
+		regressed, err := cmd.isRegression(baseline, versions[0])
+		if err != nil {
+			return errors.Wrap(err, "isRegression")
+		}
+		if regressed {
+			firstBad = mid
+		} else {
+			lastGood = mid
+		}
+	}
+
+	summary, err := git(root, "log", "-1", "--format=%h %s", commits[firstBad])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("first bad commit: %s\n\n", summary)
+	return cmd.printCmp(baseline, tested[commits[firstBad]])
+}
+
+// schedCommits creates a version for each of the given commits and waits until all of them are completed.
+// Versions of a previous bisection with the same spec are reused
+func (cmd *bisectCmd) schedCommits(ctx context.Context, shas ...string) ([]string, error) {
+	forward := []string{"go", "test", "-run=^$", "-bench=" + cmd.bench, "-benchmem", fmt.Sprintf("-count=%d", cmd.count)}
+	run, tmpl, err := configuredRun(ctx, cmd.docker, cmd.profile)
+	if err != nil {
+		return nil, errors.Wrap(err, "configuredRun")
+	}
+	versions, created, err := run.prepareCommits(ctx, tmpl, "bisect", shas, forward)
+	if err != nil {
+		return nil, errors.Wrap(err, "prepareCommits")
+	}
+	if len(created) > 0 {
+		err = runServerCmd(ctx, cmd.docker, append([]string{"sched"}, created...), run.serverCpuset, run.pull, os.Getenv("BENCHER_DEBUG") != "")
+		if err != nil {
+			return nil, errors.Wrap(err, "runServerCmd")
+		}
+	}
+	return versions, waitJobs(ctx, cmd.docker, versions)
+}
+
+// isRegression reports whether any benchmark of the given version is significantly worse than the baseline
+// by more than the threshold
+func (cmd *bisectCmd) isRegression(baseline, version string) (bool, error) {
+	db, err := initDB()
+	if err != nil {
+		return false, errors.Wrap(err, "initDB")
+	}
+	jobs, err := getJobs(db, baseline, version)
+	db.Close()
+	if err != nil {
+		return false, errors.Wrap(err, "getJobs")
+	}
+	if len(jobs) != 2 {
+		return false, fmt.Errorf("versions %s and %s must be completed", baseline, version)
+	}
+	c := &benchstat.Collection{}
+	for _, job := range jobs {
+		if job.Status() != "done" {
+			return false, fmt.Errorf("version %s is %s:\n%s", job.Version, job.Status(), job.Stderr)
+		}
+		err := c.AddFile(job.Version, bytes.NewBufferString(job.Stdout))
+		if err != nil {
+			return false, errors.Wrap(err, "benchstat.Collection.AddFile")
+		}
+	}
+	var compared int
+	for _, table := range c.Tables() {
+		if table.Metric != cmd.metric {
+			continue
+		}
+		compared += len(table.Rows)
+		for _, row := range table.Rows {
+			if row.Change < 0 && math.Abs(row.PctDelta) > cmd.threshold {
+				return true, nil
+			}
+		}
+	}
+	if compared == 0 {
+		fmt.Printf("warning: %s and %s have no %s results in common, check that --bench matches some benchmarks\n", baseline, version, cmd.metric)
+	}
+	return false, nil
+}
+
+func (cmd *bisectCmd) printCmp(versions ...string) error {
+	db, err := initDB()
+	if err != nil {
+		return errors.Wrap(err, "initDB")
+	}
+	defer db.Close()
+	return (&cmpCmd{docker: cmd.docker}).cmp(db, versions...)
+}
+
+func (cmd *bisectCmd) Synopsis() string {
+	return `find the commit that caused a regression`
+}
+
+func (cmd *bisectCmd) Help() string {
+	return `Usage: bencher bisect --good <ref> [--bad] [--bench] [--metric] [--threshold] [--count] [-p]
+
+Bisect the first-parent history between the --good and [--bad] (default: HEAD) refs to find the first commit whose benchmarks are worse than the good one.
+Each tested commit is exported from the local git history and scheduled as a version named "bisect-<commit>-<hash of the command, env and image>".
+It is reused by later bisections with the same [--bench], [--count] and profile, unless it failed, in which case it is recreated.
+
+A commit is bad when benchstat reports a significant change to worse above the [--threshold] (default: 5%) on the [--metric] (default: ns/op, also B/op, allocs/op or MB/s)
+for any of the benchmarks matched by [--bench] (default: .). Each run takes [--count] (default: 10) samples.
+The versions are created with the defaults of the config files (see "bencher run"), using the profile given by [-p] if any (its matrix is ignored)`
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	volumetypes "github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-units"
+	"github.com/mitchellh/cli"
+	"github.com/pkg/errors"
+	"github.com/schattian/bencher/internal/bencher"
+)
+
+const (
+	cacheLabel       = bencher.ContainersLabel + ".image"
+	runnerGoCache    = "/cache/go-build"
+	runnerGoModCache = "/cache/mod"
+)
+
+type cacheCmd struct {
+	docker *client.Client
+}
+
+func prepareCache() (cli.Command, error) {
+	docker, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheCmd{docker: docker}, nil
+}
+
+func (cmd *cacheCmd) Run(args []string) int {
+	fs := newFlagSet("cache")
+	args, ok := parseInterspersed(fs, args)
+	if !ok || len(args) != 1 {
+		return cli.RunResultHelp
+	}
+	var err error
+	switch args[0] {
+	case "ls":
+		err = errors.Wrap(cmd.printListCaches(context.Background()), "printListCaches")
+	case "prune":
+		err = errors.Wrap(cmd.prune(context.Background()), "prune")
+	default:
+		return cli.RunResultHelp
+	}
+	if err != nil {
+		fmt.Printf("err %v", err)
+		return 1
+	}
+	return 0
+}
+
+func (cmd *cacheCmd) printListCaches(ctx context.Context) error {
+	du, err := cmd.docker.DiskUsage(ctx)
+	if err != nil {
+		return errors.Wrap(err, "DiskUsage")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 3, 3, 3, ' ', 0)
+	fmt.Fprintln(w, "name\timage\tsize\t")
+	for _, v := range du.Volumes {
+		if v.Labels[bencher.ContainersLabel] != "cache" {
+			continue
+		}
+		size := "unknown"
+		if v.UsageData != nil && v.UsageData.Size >= 0 {
+			size = units.BytesSize(float64(v.UsageData.Size))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t\n", v.Name, v.Labels[cacheLabel], size)
+	}
+	return w.Flush()
+}
+
+func (cmd *cacheCmd) prune(ctx context.Context) error {
+	report, err := cmd.docker.VolumesPrune(ctx, filters.NewArgs(filters.Arg("label", fmt.Sprintf("%s=cache", bencher.ContainersLabel))))
+	if err != nil {
+		return errors.Wrap(err, "VolumesPrune")
+	}
+	fmt.Printf("removed %d cache volume(s), reclaimed %s\n", len(report.VolumesDeleted), units.BytesSize(float64(report.SpaceReclaimed)))
+	return nil
+}
+
+// cacheVolumes are the cache volumes mounted on the runners of the given image
+func cacheVolumes(image string) []mount.Mount {
+	key := fmt.Sprintf("%x", sha256.Sum256([]byte(image)))[:12]
+	if i := strings.Index(image, "@sha256:"); i >= 0 {
+		key = image[i+len("@sha256:"):][:12]
+	}
+	var mounts []mount.Mount
+	for _, cache := range []struct{ kind, target string }{{"gocache", runnerGoCache}, {"gomodcache", runnerGoModCache}} {
+		name := fmt.Sprintf("%s_%s_%s", bencher.ContainersLabel, cache.kind, key)
+		mounts = append(mounts, mount.Mount{Type: mount.TypeVolume, Source: name, Target: cache.target})
+	}
+	return mounts
+}
+
+// cacheMounts creates the cache volumes of the given image, if they don't exist yet
+func cacheMounts(ctx context.Context, docker *client.Client, image string) ([]mount.Mount, error) {
+	mounts := cacheVolumes(image)
+	for _, m := range mounts {
+		_, err := docker.VolumeCreate(ctx, volumetypes.VolumeCreateBody{
+			Name:   m.Source,
+			Labels: map[string]string{bencher.ContainersLabel: "cache", cacheLabel: image},
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "VolumeCreate[%s]", m.Source)
+		}
+	}
+	return mounts, nil
+}
+
+func (cmd *cacheCmd) Synopsis() string {
+	return `list or prune the build cache volumes`
+}
+
+func (cmd *cacheCmd) Help() string {
+	return `Usage: bencher cache <ls|prune>
+
+The runners share GOCACHE and GOMODCACHE docker volumes (one pair per image digest), so only the first run of a module compiles it from scratch.
+"ls" lists the volumes with their size, and "prune" removes the ones that aren't used by any runner`
+}
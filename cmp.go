@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/docker/docker/client"
 	"github.com/mitchellh/cli"
@@ -18,6 +19,9 @@ import (
 
 type cmpCmd struct {
 	docker *client.Client
+
+	byCommit bool
+	axis     string // matrix variable to group by
 }
 
 func prepareCmp() (cli.Command, error) {
@@ -30,7 +34,13 @@ func prepareCmp() (cli.Command, error) {
 }
 
 func (cmd *cmpCmd) Run(args []string) int {
-	if len(args) < 2 {
+	fs := newFlagSet("cmp")
+	fs.BoolVar(&cmd.byCommit, "by-commit", false, "")
+	fs.StringVar(&cmd.axis, "axis", "", "")
+	var tags stringsFlag
+	fs.Var(&tags, "tag", "")
+	args, ok := parseInterspersed(fs, args)
+	if !ok || (len(args) < 1 && len(tags) == 0) {
 		return cli.RunResultHelp
 	}
 	db, err := initDB()
@@ -39,6 +49,17 @@ func (cmd *cmpCmd) Run(args []string) int {
 		return 1
 	}
 	defer db.Close()
+	if len(tags) > 0 {
+		args, err = selectTagged(db, tags, args, false)
+		if err != nil {
+			fmt.Printf("err selectTagged: %v", err)
+			return 1
+		}
+		if len(args) < 2 {
+			fmt.Printf("err %d completed versions tagged %s, at least two are needed to compare", len(args), strings.Join(tags, ","))
+			return 1
+		}
+	}
 	err = cmd.cmp(db, args...)
 	if err != nil {
 		log.Fatal(err)
@@ -66,33 +87,127 @@ func getJobs(db *bbolt.DB, versions ...string) (jobs []*bencher.Job, err error)
 	return
 }
 
+// getVariants returns the jobs of the matrix on the given version
+func getVariants(db *bbolt.DB, base string) (variants []*bencher.Job, err error) {
+	jobs, err := listJobs(db)
+	for _, j := range jobs {
+		if j.Base == base {
+			variants = append(variants, j)
+		}
+	}
+	return
+}
+
 func (cmd *cmpCmd) cmp(db *bbolt.DB, versions ...string) error {
+	if len(versions) == 1 {
+		variants, err := getVariants(db, versions[0])
+		if err != nil {
+			return errors.Wrap(err, "getVariants")
+		}
+		if len(variants) == 0 {
+			return fmt.Errorf("%s isn't a matrix, give at least two versions to compare", versions[0])
+		}
+		return cmd.cmpMatrix(variants)
+	}
 	jobs, err := getJobs(db, versions...)
 	if err != nil {
 		return errors.Wrap(err, "getJobs")
 	}
+	if cmd.byCommit {
+		bencher.SortByCommit(jobs)
+	}
+	warnCpusetMismatch(jobs)
+	return printBenchstat(jobs, func(j *bencher.Job) string { return j.Version })
+}
+
+// cmpMatrix compares the variants along each axis (or the given one), grouping them by the values of the other axes
+func (cmd *cmpCmd) cmpMatrix(variants []*bencher.Job) error {
+	var axes []string
+	for _, assignment := range variants[0].Variant {
+		axes = append(axes, strings.SplitN(assignment, "=", 2)[0])
+	}
+	if cmd.axis != "" {
+		if !isInStrSl(cmd.axis, axes) {
+			return fmt.Errorf("%s isn't an axis of the matrix, must be one of %s", cmd.axis, strings.Join(axes, ", "))
+		}
+		axes = []string{cmd.axis}
+	}
+	for i, axis := range axes {
+		var groups []string
+		grouped := make(map[string][]*bencher.Job)
+		for _, j := range variants {
+			var others []string
+			for _, assignment := range j.Variant {
+				if !strings.HasPrefix(assignment, axis+"=") {
+					others = append(others, assignment)
+				}
+			}
+			group := strings.Join(others, " ")
+			if _, ok := grouped[group]; !ok {
+				groups = append(groups, group)
+			}
+			grouped[group] = append(grouped[group], j)
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("by %s\n", axis)
+		for _, group := range groups {
+			if group != "" {
+				fmt.Printf("\n%s\n", group)
+			}
+			err := printBenchstat(grouped[group], func(j *bencher.Job) string {
+				for _, assignment := range j.Variant {
+					if strings.HasPrefix(assignment, axis+"=") {
+						return assignment
+					}
+				}
+				return j.Version
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func printBenchstat(jobs []*bencher.Job, config func(*bencher.Job) string) error {
 	c := &benchstat.Collection{}
 	for _, job := range jobs {
 		if job.Status() != "done" {
 			continue
 		}
-		err := c.AddFile(job.Version, bytes.NewBufferString(job.Stdout))
+		err := c.AddFile(config(job), bytes.NewBufferString(job.Stdout))
 		if err != nil {
 			return errors.Wrap(err, "benchstat.Collection.AddFile: %v")
 		}
 	}
 	var buf bytes.Buffer
 	benchstat.FormatText(&buf, c.Tables())
-	_, err = os.Stdout.Write(buf.Bytes())
+	_, err := os.Stdout.Write(buf.Bytes())
 	return errors.Wrap(err, "os.Stdout.Write")
 }
 
+func warnCpusetMismatch(jobs []*bencher.Job) {
+	for _, job := range jobs {
+		if job.Limits.Cpuset != jobs[0].Limits.Cpuset {
+			fmt.Printf("warning: versions were pinned to different cpus (%s: %q, %s: %q)\n", jobs[0].Version, jobs[0].Limits.Cpuset, job.Version, job.Limits.Cpuset)
+			return
+		}
+	}
+}
+
 func (cmd *cmpCmd) Synopsis() string {
 	return `compare two or more versions`
 }
 
 func (cmd *cmpCmd) Help() string {
-	return `Usage: bencher cmp <version1> <version2> [version3] [...]
+	return `Usage: bencher cmp [--by-commit] <version1> <version2> [version3] [...]
+       bencher cmp [--by-commit] --tag <tag> [version1] [...]
+       bencher cmp [--axis] <matrix version>
 
-Compare two or more versions with benchstat`
+Compare two or more versions with benchstat. If [--by-commit] is given, the versions are sorted in the order of their commits instead of the given one
+If [--tag] is given (can be given multiple times), the completed versions tagged with all of them are compared, or just the given ones having them (see "bencher tag")
+If a version ran with --matrix is given, its variants are compared along each variable (or just the given [--axis]), grouped by the values of the rest`
 }
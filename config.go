@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"github.com/schattian/bencher/internal/bencher"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	projectConfigFilenames = []string{".bencher.toml", ".bencher.yaml", ".bencher.yml"}
+	userConfigFilenames    = []string{"config.toml", "config.yaml", "config.yml"}
+)
+
+// config are the defaults of bencher run, given by a .bencher.toml or .bencher.yaml file at the module root and by a
+// config file on ~/.bencher. Its keys are named after the flags of run
+type config struct {
+	Image         string                   `toml:"image" yaml:"image"`
+	Cmd           []string                 `toml:"cmd" yaml:"cmd"`
+	Env           []string                 `toml:"env" yaml:"env"`
+	EnvFile       []string                 `toml:"env-file" yaml:"env-file"`
+	CPUs          float64                  `toml:"cpus" yaml:"cpus"`
+	Memory        string                   `toml:"memory" yaml:"memory"`
+	PidsLimit     int64                    `toml:"pids-limit" yaml:"pids-limit"`
+	Cpuset        string                   `toml:"cpuset" yaml:"cpuset"`
+	Pull          string                   `toml:"pull" yaml:"pull"`
+	Include       []string                 `toml:"include" yaml:"include"`
+	Ignore        []string                 `toml:"ignore" yaml:"ignore"`
+	SnapshotLimit string                   `toml:"snapshot-limit" yaml:"snapshot-limit"`
+	Timeout       string                   `toml:"timeout" yaml:"timeout"`
+	MaxTimeout    string                   `toml:"max-timeout" yaml:"max-timeout"`
+	Network       string                   `toml:"network" yaml:"network"`
+	Priority      string                   `toml:"priority" yaml:"priority"`
+	QuietFor      string                   `toml:"quiet-for" yaml:"quiet-for"`
+	QuietLoad     float64                  `toml:"quiet-load" yaml:"quiet-load"`
+	QuietCPU      float64                  `toml:"quiet-cpu" yaml:"quiet-cpu"`
+	Setup         []string                 `toml:"setup" yaml:"setup"`
+	Matrix        []string                 `toml:"matrix" yaml:"matrix"`
+	Sidecars      map[string]sidecarConfig `toml:"sidecars" yaml:"sidecars"`
+	Profiles      map[string]*config       `toml:"profiles" yaml:"profiles"`
+}
+
+type sidecarConfig struct {
+	Image       string   `toml:"image" yaml:"image"`
+	Env         []string `toml:"env" yaml:"env"`
+	Healthcheck string   `toml:"healthcheck" yaml:"healthcheck"`
+}
+
+// loadConfig reads the user config followed by the project one of the module on root (if given), and applies the
+// given profile on top of them
+func loadConfig(root, profile string) (*config, error) {
+	c := &config{}
+	files := []string{findConfig(bencher.HostRootPath, userConfigFilenames)}
+	if root != "" {
+		files = append(files, findConfig(root, projectConfigFilenames))
+	}
+	for _, filename := range files {
+		if filename == "" {
+			continue
+		}
+		fileConfig, err := readConfig(filename)
+		if err != nil {
+			return nil, errors.Wrapf(err, "readConfig[%s]", filename)
+		}
+		c.merge(fileConfig)
+	}
+	if profile == "" {
+		return c, nil
+	}
+	p, ok := c.Profiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("profile %q isn't defined on the config files", profile)
+	}
+	c.merge(p)
+	return c, nil
+}
+
+// findConfig returns the first of the given config files that exists on dir, if any
+func findConfig(dir string, filenames []string) string {
+	for _, filename := range filenames {
+		p := filepath.Join(dir, filename)
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// readConfig decodes a toml or yaml config file, failing on unknown keys. Its env files are read relative to its dir
+func readConfig(filename string) (*config, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	c := &config{}
+	if filepath.Ext(filename) == ".toml" {
+		md, err := toml.Decode(string(b), c)
+		if err != nil {
+			return nil, err
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("unknown key %s", undecoded[0])
+		}
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		err = dec.Decode(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = c.readEnvFiles(filepath.Dir(filename))
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// readEnvFiles prepends the variables of the env files to the env of the config and its profiles
+func (c *config) readEnvFiles(dir string) error {
+	var env []string
+	for _, filename := range c.EnvFile {
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(dir, filename)
+		}
+		vars, err := readEnvFile(filename)
+		if err != nil {
+			return errors.Wrap(err, "readEnvFile")
+		}
+		env = append(env, vars...)
+	}
+	for _, v := range c.Env {
+		env = append(env, expandEnv(v))
+	}
+	c.Env, c.EnvFile = env, nil
+	for name, p := range c.Profiles {
+		if p == nil {
+			return fmt.Errorf("profile %q is empty", name)
+		}
+		err := p.readEnvFiles(dir)
+		if err != nil {
+			return errors.Wrapf(err, "profile[%s]", name)
+		}
+	}
+	return nil
+}
+
+// merge sets the keys given on o: its env is added to the current one, its sidecars and profiles are added by name
+// and the rest of its keys replace the current ones
+func (c *config) merge(o *config) {
+	env := append(c.Env[:len(c.Env):len(c.Env)], o.Env...)
+	cv, ov := reflect.ValueOf(c).Elem(), reflect.ValueOf(o).Elem()
+	for i := 0; i < cv.NumField(); i++ {
+		field, value := cv.Field(i), ov.Field(i)
+		if value.IsZero() {
+			continue
+		}
+		if field.Kind() == reflect.Map {
+			if field.IsNil() {
+				field.Set(reflect.MakeMap(field.Type()))
+			}
+			for iter := value.MapRange(); iter.Next(); {
+				field.SetMapIndex(iter.Key(), iter.Value())
+			}
+			continue
+		}
+		field.Set(value)
+	}
+	c.Env = env
+}
+
+// apply sets the flags of run that weren't given on the command line from the config. The env of the config is
+// kept apart so the given one is added to it, and the sidecars it declares are used unless --sidecar is given
+func (c *config) apply(f *runFlags, given map[string]bool) {
+	setString := func(name string, dst *string, v string) {
+		if !given[name] && v != "" {
+			*dst = v
+		}
+	}
+	setStrings := func(name string, dst *stringsFlag, v []string) {
+		if !given[name] && len(v) > 0 {
+			*dst = v
+		}
+	}
+	formatFloat := func(v float64) string {
+		if v == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	setString("image", &f.image, c.Image)
+	setString("cpus", &f.cpus, formatFloat(c.CPUs))
+	setString("memory", &f.memory, c.Memory)
+	if c.PidsLimit != 0 {
+		setString("pids-limit", &f.pidsLimit, strconv.FormatInt(c.PidsLimit, 10))
+	}
+	setString("cpuset", &f.cpuset, c.Cpuset)
+	setString("pull", &f.pull, c.Pull)
+	setStrings("include", &f.includes, c.Include)
+	setString("snapshot-limit", &f.snapshotLimit, c.SnapshotLimit)
+	setString("timeout", &f.timeout, c.Timeout)
+	setString("network", &f.network, c.Network)
+	setString("priority", &f.priority, c.Priority)
+	setString("quiet-for", &f.quietFor, c.QuietFor)
+	setString("quiet-load", &f.quietLoad, formatFloat(c.QuietLoad))
+	setString("quiet-cpu", &f.quietCPU, formatFloat(c.QuietCPU))
+	setStrings("setup", &f.setup, c.Setup)
+	setStrings("matrix", &f.matrix, c.Matrix)
+	f.cmd, f.baseEnv, f.ignores, f.maxTimeout = c.Cmd, c.Env, c.Ignore, c.MaxTimeout
+
+	if given["sidecar"] {
+		return
+	}
+	var names []string
+	for name := range c.Sidecars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sidecars, envs, healthchecks []string
+	for _, name := range names {
+		sc := c.Sidecars[name]
+		sidecars = append(sidecars, fmt.Sprintf("%s=%s", name, sc.Image))
+		for _, v := range sc.Env {
+			envs = append(envs, fmt.Sprintf("%s:%s", name, v))
+		}
+		if sc.Healthcheck != "" {
+			healthchecks = append(healthchecks, fmt.Sprintf("%s:%s", name, sc.Healthcheck))
+		}
+	}
+	f.sidecars = sidecars
+	f.sidecarEnv = append(envs, f.sidecarEnv...)
+	f.sidecarHealth = append(healthchecks, f.sidecarHealth...)
+}
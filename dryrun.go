@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+	"github.com/pkg/errors"
+	"github.com/schattian/bencher/internal/bencher"
+)
+
+// dryRun prints what prepareRuntime would do for the job, without copying nor creating anything
+func (cmd *runCmd) dryRun(ctx context.Context, j *bencher.Job, forward []string) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return errors.Wrap(err, "Getwd")
+	}
+	root, err := getModPath()
+	if err != nil {
+		return errors.Wrap(err, "getModPath")
+	}
+	fmt.Printf("module root: %s\nworking dir: %s\n", root, wd)
+
+	if j.Commit != "" {
+		j.CommitTime, j.CommitSubject, err = commitInfo(root, j.Commit)
+		if err != nil {
+			return errors.Wrap(err, "commitInfo")
+		}
+		fmt.Printf("commit: %s (%s) %s\n", j.Commit, j.CommitTime.Format(time.RFC3339), j.CommitSubject)
+	}
+	snapshot, layout, cleanup, err := cmd.listSnapshot(j, root, false) // commits are listed from their tree, nothing is exported
+	if err != nil {
+		return errors.Wrap(err, "listSnapshot")
+	}
+	defer cleanup()
+	if layout.base != root {
+		fmt.Printf("layout base: %s (modules: %s)\n", layout.base, strings.Join(layout.dirs, ", "))
+	}
+	if layout.work != "" {
+		fmt.Printf("workspace: %s\n", layout.work)
+	}
+	fmt.Printf("snapshot of %d files, %s (%s/vendor/ is added when vendoring):\n", len(snapshot.entries), units.BytesSize(float64(snapshot.size)), layout.rel(layout.vendorDir()))
+	for _, e := range snapshot.entries {
+		fmt.Printf("\t%s\t%s\n", e.path, units.BytesSize(float64(e.size)))
+	}
+	if cmd.snapshotLimit > 0 && snapshot.size > cmd.snapshotLimit {
+		fmt.Printf("warning: the snapshot exceeds the limit of %s\n", units.BytesSize(float64(cmd.snapshotLimit)))
+	}
+
+	if j.Image == "" {
+		j.Image, err = moduleImage(filepath.Join(snapshot.root, filepath.FromSlash(layout.rel(root))), snapshot.fsys.ReadFile)
+		if err != nil {
+			return errors.Wrap(err, "moduleImage")
+		}
+	}
+	fmt.Printf("image: %s\n", j.Image)
+	digest, err := resolveDigest(ctx, cmd.docker, j.Image)
+	if err != nil {
+		fmt.Printf("digest: unknown, the image isn't on the docker host (--pull %s)\n", cmd.pull)
+	} else {
+		fmt.Printf("digest: %s\n", digest)
+		j.Image = digest
+	}
+	for i, sc := range j.Sidecars {
+		j.Sidecars[i].Addr, err = sidecarAddr(ctx, cmd.docker, &sc)
+		if err != nil {
+			j.Sidecars[i].Addr = sc.Name // its ports are unknown until pulled
+		}
+	}
+
+	j.Cmd, j.Dir = cmd.commandOrDefault(forward), layout.workingDir(wd)
+	versionPath := filepath.Join(bencher.HostVersionsPath, j.Version)
+	for _, v := range cmd.jobs(j) {
+		config, hostConfig := runnerConfig(v, versionPath, cacheVolumes(v.Image))
+		fmt.Printf("\nversion: %s\n", v.Version)
+		if len(v.Env) > 0 {
+			fmt.Printf("env:\n\t%s\n", strings.Join(v.Env, "\n\t"))
+		}
+		fmt.Printf("limits: %s\n", v.Limits)
+		for _, sc := range v.Sidecars {
+			fmt.Printf("sidecar: %s (%s) %s\n", sc.Name, sc.Addr, sc.Image)
+		}
+		fmt.Printf("command: %s\n", strings.Join(v.Cmd, " "))
+		fmt.Print("container: ")
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false) // keep the shell of the command readable
+		enc.SetIndent("", "  ")
+		err = enc.Encode(struct {
+			Config     *container.Config
+			HostConfig *container.HostConfig
+		}{config, hostConfig})
+		if err != nil {
+			return errors.Wrap(err, "Encode")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/docker/go-units"
+	"github.com/mitchellh/cli"
+	"github.com/pkg/errors"
+	"github.com/schattian/bencher/internal/bencher"
+)
+
+type duCmd struct{}
+
+func prepareDu() (cli.Command, error) {
+	return &duCmd{}, nil
+}
+
+func (cmd *duCmd) Run(args []string) int {
+	fs := newFlagSet("du")
+	args, ok := parseInterspersed(fs, args)
+	if !ok || len(args) != 0 {
+		return cli.RunResultHelp
+	}
+	err := cmd.printUsage()
+	if err != nil {
+		fmt.Printf("err printUsage: %v", err)
+		return 1
+	}
+	return 0
+}
+
+func (cmd *duCmd) printUsage() error {
+	manifests, err := os.ReadDir(bencher.HostManifestsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 3, 3, 3, ' ', 0)
+	fmt.Fprintln(w, "name\tfiles\tsize\t")
+	var total int64
+	for _, e := range manifests {
+		version := strings.TrimSuffix(e.Name(), ".json")
+		m, err := readManifest(version)
+		if err != nil {
+			return errors.Wrapf(err, "readManifest[%s]", version)
+		}
+		total += m.size()
+		fmt.Fprintf(w, "%s\t%d\t%s\t\n", version, len(m.Files), units.BytesSize(float64(m.size())))
+	}
+	w.Flush()
+
+	var stored int64
+	err = filepath.WalkDir(bencher.HostBlobsPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		stored += info.Size()
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "WalkDir")
+	}
+	fmt.Printf("\nstore: %s on disk for %s of versions\n", units.BytesSize(float64(stored)), units.BytesSize(float64(total)))
+	return nil
+}
+
+func (cmd *duCmd) Synopsis() string {
+	return `print the disk usage of the versions`
+}
+
+func (cmd *duCmd) Help() string {
+	return `Usage: bencher du
+
+Print the size of each version and the actual disk usage of the store, where the files are shared across versions`
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// stringsFlag is a repeatable flag, keeping all the given values in order
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// newFlagSet returns the flag set of a command. Its errors are printed by parseFlags, followed by the help of the command
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Usage = func() {}
+	return fs
+}
+
+// parseFlags parses the flags given before the args of a command, which are left untouched on fs.Args() (e.g: the
+// go test command to forward). "--" can be given to end the flags explicitly.
+// It reports whether the flags were valid, printing the error otherwise
+func parseFlags(fs *flag.FlagSet, args []string) bool {
+	err := fs.Parse(args)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		fmt.Printf("err %v\n", err)
+	}
+	return err == nil
+}
+
+// parseInterspersed parses the flags of a command that doesn't forward args, so they can be given among its args
+// (e.g: bencher rm v1 v2 -f). The args given after "--" are never taken as flags
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, bool) {
+	var positional []string
+	for {
+		if !parseFlags(fs, args) {
+			return nil, false
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, true
+		}
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), true
+		}
+		positional, args = append(positional, rest[0]), rest[1:]
+	}
+}
+
+// givenFlags returns the names of the flags given on the command line
+func givenFlags(fs *flag.FlagSet) map[string]bool {
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	return given
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/docker/docker/client"
 	"github.com/mitchellh/cli"
@@ -29,6 +30,14 @@ func prepareGet() (cli.Command, error) {
 }
 
 func (cmd *getCmd) Run(args []string) int {
+	fs := newFlagSet("get")
+	byCommit := fs.Bool("by-commit", false, "")
+	var tags stringsFlag
+	fs.Var(&tags, "tag", "")
+	args, ok := parseInterspersed(fs, args)
+	if !ok {
+		return cli.RunResultHelp
+	}
 	db, err := initDB()
 	if err != nil {
 		fmt.Printf("err initDB: %v", err)
@@ -37,7 +46,7 @@ func (cmd *getCmd) Run(args []string) int {
 	defer db.Close()
 	switch len(args) {
 	case 0:
-		err = errors.Wrap(cmd.printListJobs(db), "printListJobs")
+		err = errors.Wrap(cmd.printListJobs(db, *byCommit, tags), "printListJobs")
 	case 1:
 		err = errors.Wrap(cmd.printJobDetail(db, args[0]), "printJobDetail")
 	default:
@@ -66,7 +75,13 @@ func (cmd *getCmd) printJobDetail(db *bbolt.DB, version string) error {
 	if err != nil {
 		return errors.Wrap(err, "db.View")
 	}
+	status := j.Status()
 	if j.Version == "" {
+		j, err = bencher.GetPending(db, version)
+		if err != nil {
+			return errors.Wrap(err, "GetPending")
+		}
+		status = "scheduled"
 		v, err := getRunningVersion()
 		if os.IsNotExist(err) {
 			err = nil
@@ -74,13 +89,82 @@ func (cmd *getCmd) printJobDetail(db *bbolt.DB, version string) error {
 		if err != nil {
 			return errors.Wrap(err, "getRunningVersion")
 		}
-		if v != version {
+		if v == version {
+			status = runningStatus(version)
+		} else if !isPending(db, version) {
 			fmt.Printf("job %s not found", version)
 			return nil
 		}
-		j.Version = v
+		db.View(func(tx *bbolt.Tx) error {
+			for i, pendingVer := range bencher.Queue(tx) {
+				if pendingVer == version {
+					status = fmt.Sprintf("scheduled at order #%d", i)
+				}
+			}
+			return nil
+		})
+	}
+	detail := fmt.Sprintf("name: %s\nstatus: %s\nimage: %s\nlimits: %s", j.Version, status, j.Image, j.Limits)
+	if len(j.Tags) > 0 {
+		detail = fmt.Sprintf("%s\ntags: %s", detail, strings.Join(j.Tags, ", "))
+	}
+	if j.Note != "" {
+		detail = fmt.Sprintf("%s\nnote: %s", detail, j.Note)
+	}
+	if j.Base != "" {
+		detail = fmt.Sprintf("%s\nmatrix: %s (%s)", detail, j.Base, strings.Join(j.Variant, " "))
+	}
+	if j.Commit != "" {
+		detail = fmt.Sprintf("%s\ncommit: %s", detail, j.Commit)
+		if !j.CommitTime.IsZero() {
+			detail = fmt.Sprintf("%s (%s) %s", detail, j.CommitTime.Format(time.RFC3339), j.CommitSubject)
+		}
+	}
+	if j.Head != "" {
+		detail = fmt.Sprintf("%s\nhead: %s", detail, j.Head)
+		if j.Diff != "" {
+			detail = fmt.Sprintf("%s (with uncommitted changes)", detail)
+		}
+	}
+	if j.Profile != "" {
+		detail = fmt.Sprintf("%s\nprofile: %s", detail, j.Profile)
+	}
+	if j.Network != "" {
+		detail = fmt.Sprintf("%s\nnetwork: %s", detail, j.Network)
+	}
+	if j.Priority != "" {
+		detail = fmt.Sprintf("%s\npriority: %s", detail, j.Priority)
+	}
+	if j.Quiet.For > 0 {
+		detail = fmt.Sprintf("%s\nquiet gate: load <= %g, others cpu <= %g%% for %s", detail, j.Quiet.Load, j.Quiet.CPU, j.Quiet.For)
+	}
+	if j.HostLoad != nil {
+		detail = fmt.Sprintf("%s\nhost: %s", detail, j.HostLoad)
+	}
+	if j.Timeout > 0 {
+		detail = fmt.Sprintf("%s\ntimeout: %s", detail, j.Timeout)
+	}
+	if j.CompileTime > 0 {
+		detail = fmt.Sprintf("%s\ncompile time: %s", detail, j.CompileTime)
+	}
+	if len(j.Env) > 0 {
+		detail = fmt.Sprintf("%s\nenv:\n\t%s", detail, strings.Join(j.Env, "\n\t"))
+	}
+	for _, sc := range j.Sidecars {
+		detail = fmt.Sprintf("%s\nsidecar: %s (%s) %s", detail, sc.Name, sc.Addr, sc.Image)
+	}
+	if len(j.Setup) > 0 {
+		detail = fmt.Sprintf("%s\nsetup:\n\t%s", detail, strings.Join(j.Setup, "\n\t"))
+	}
+	if len(j.FailedRounds) > 0 {
+		detail = fmt.Sprintf("%s\nfailed rounds:\n\t%s", detail, strings.Join(j.FailedRounds, "\n\t"))
+	}
+	if j.Diff != "" {
+		detail = fmt.Sprintf("%s\ndiff:\n\t%s", detail, strings.ReplaceAll(j.Diff, "\n", "\n\t"))
+	}
+	if j.SetupOutput != "" {
+		detail = fmt.Sprintf("%s\nsetup output:\n\t%s", detail, strings.ReplaceAll(j.SetupOutput, "\n", "\n\t"))
 	}
-	detail := fmt.Sprintf("name: %s\nstatus: %s", j.Version, j.Status())
 	if j.Stdout != "" {
 		detail = fmt.Sprintf("%s\noutput:\n\t%s", detail, strings.ReplaceAll(j.Stdout, "\n", "\n\t"))
 	}
@@ -91,15 +175,35 @@ func (cmd *getCmd) printJobDetail(db *bbolt.DB, version string) error {
 	return nil
 }
 
-func (cmd *getCmd) printListJobs(db *bbolt.DB) error {
-	jobs, err := listJobs(db)
+// printListJobs lists the jobs tagged with all the given tags
+func (cmd *getCmd) printListJobs(db *bbolt.DB, byCommit bool, tags []string) error {
+	all, err := listJobs(db)
 	if err != nil {
 		return err
 	}
+	var jobs []*bencher.Job
+	for _, job := range all {
+		if job.HasTags(tags) {
+			jobs = append(jobs, job)
+		}
+	}
 	w := tabwriter.NewWriter(os.Stdout, 3, 3, 3, ' ', 0)
-	fmt.Fprintln(w, "name\tstatus\t")
+	if byCommit {
+		bencher.SortByCommit(jobs)
+		fmt.Fprintln(w, "name\tstatus\tcommitted at\tsubject\t")
+		for _, job := range jobs {
+			var committedAt string
+			if !job.CommitTime.IsZero() {
+				committedAt = job.CommitTime.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t\n", job.Version, job.Status(), committedAt, job.CommitSubject)
+		}
+		w.Flush()
+		return nil
+	}
+	fmt.Fprintln(w, "name\tstatus\ttags\t")
 	for _, job := range jobs {
-		fmt.Fprintf(w, "%s\t%s\t\n", job.Version, job.Status())
+		fmt.Fprintf(w, "%s\t%s\t%s\t\n", job.Version, job.Status(), strings.Join(job.Tags, ","))
 	}
 
 	runningVer, err := getRunningVersion()
@@ -110,27 +214,59 @@ func (cmd *getCmd) printListJobs(db *bbolt.DB) error {
 		return errors.Wrap(err, "getRunningVersion")
 	}
 	if runningVer != "" {
-		fmt.Fprintf(w, "%s\t%s\t\n", runningVer, "running")
-	}
-	err = db.View(func(tx *bbolt.Tx) error {
-		bSched := tx.Bucket(bencher.KeySched)
-		if bSched == nil {
-			return nil
+		j, err := bencher.GetPending(db, runningVer)
+		if err != nil {
+			return errors.Wrap(err, "GetPending")
 		}
-		sched := string(bSched.Get(bencher.KeySched))
-		for i, pendingVer := range strings.Split(sched, ",") {
-			if pendingVer == "" {
-				continue
-			}
-			fmt.Fprintf(w, "%s\t%s\t\n", pendingVer, fmt.Sprintf("scheduled at order #%d", i))
+		if j.HasTags(tags) {
+			fmt.Fprintf(w, "%s\t%s\t%s\t\n", runningVer, runningStatus(runningVer), strings.Join(j.Tags, ","))
 		}
+	}
+	var queue []string
+	err = db.View(func(tx *bbolt.Tx) error {
+		queue = bencher.Queue(tx)
 		return nil
 	})
+	if err != nil {
+		return errors.Wrap(err, "db.View")
+	}
+	for i, pendingVer := range queue {
+		status := fmt.Sprintf("scheduled at order #%d", i)
+		j, err := bencher.GetPending(db, pendingVer)
+		if err != nil {
+			return errors.Wrap(err, "GetPending")
+		}
+		if !j.HasTags(tags) {
+			continue
+		}
+		if j.Priority != "" && j.Priority != bencher.PriorityNormal {
+			status = fmt.Sprintf("%s (%s priority)", status, j.Priority)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t\n", pendingVer, status, strings.Join(j.Tags, ","))
+	}
 	w.Flush()
 
 	return nil
 }
 
+// runningStatus tells whether the version holding the server is actually running or waiting for a quiet host
+func runningStatus(version string) string {
+	v, err := os.ReadFile(bencher.HostQuietFilename)
+	if err == nil && string(v) == version {
+		return "waiting for quiet host"
+	}
+	return "running"
+}
+
+func isPending(db *bbolt.DB, version string) (found bool) {
+	db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bencher.KeyPending)
+		found = b != nil && b.Get([]byte(version)) != nil
+		return nil
+	})
+	return
+}
+
 func listJobs(db *bbolt.DB) (jobs []*bencher.Job, err error) {
 	err = db.View(func(tx *bbolt.Tx) error {
 		bJobs := tx.Bucket(bencher.KeyJob)
@@ -155,7 +291,10 @@ func (cmd *getCmd) Synopsis() string {
 }
 
 func (cmd *getCmd) Help() string {
-	return `Usage: bencher get [version]
+	return `Usage: bencher get [--by-commit] [--tag] [version]
 
-Print details for the given version. In case no version is given, list all jobs. It's aliased with "ls"`
+Print details for the given version. In case no version is given, list all jobs. It's aliased with "ls"
+The details include the commit the working tree was on when run, along with the diff of its uncommitted changes
+If [--by-commit] is given, the completed jobs are listed in the order of their commits (see "bencher backfill")
+If [--tag] is given (e.g: --tag parser, can be given multiple times), only the jobs tagged with all of them are listed (see "bencher tag")`
 }
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// git runs the given git command on dir, returning its trimmed output
+func git(dir string, args ...string) (string, error) {
+	execCmd := exec.Command("git", args...)
+	execCmd.Dir = dir
+	var stderr bytes.Buffer
+	execCmd.Stderr = &stderr
+	out, err := execCmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// resolveCommit returns the full and short hashes of the commit the given ref points to
+func resolveCommit(dir, ref string) (sha, short string, err error) {
+	if strings.HasPrefix(ref, "-") {
+		return "", "", fmt.Errorf("invalid ref %q", ref)
+	}
+	sha, err = git(dir, "rev-parse", "--verify", ref+"^{commit}")
+	if err != nil {
+		return "", "", err
+	}
+	short, err = git(dir, "rev-parse", "--short", sha)
+	return sha, short, err
+}
+
+// workingTree returns the HEAD commit of the repository of dir and the diff of its working tree against it, along
+// with its current branch (empty if detached). The diff takes the untracked files that aren't ignored, as they're
+// snapshotted too. Nothing is written on the repository
+func workingTree(dir string) (head, branch, diff string, err error) {
+	head, err = git(dir, "rev-parse", "--verify", "HEAD^{commit}")
+	if err != nil {
+		return "", "", "", err
+	}
+	branch, _ = git(dir, "symbolic-ref", "--short", "-q", "HEAD")
+	toplevel, err := git(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", "", "", err
+	}
+	// the full index makes the diff of binary files change along with their content
+	diff, err = git(toplevel, "diff", "--full-index", head)
+	if err != nil {
+		return "", "", "", err
+	}
+	untracked, err := git(toplevel, "ls-files", "-z", "--others", "--exclude-standard")
+	if err != nil {
+		return "", "", "", err
+	}
+	diffs := []string{diff}
+	for _, p := range strings.Split(untracked, "\x00") {
+		if p == "" {
+			continue
+		}
+		fileDiff, err := diffUntracked(toplevel, p)
+		if err != nil {
+			return "", "", "", err
+		}
+		diffs = append(diffs, fileDiff)
+	}
+	return head, branch, strings.TrimSpace(strings.Join(diffs, "\n")), nil
+}
+
+// diffUntracked returns the diff that adds the given untracked file of the repository on toplevel
+func diffUntracked(toplevel, p string) (string, error) {
+	execCmd := exec.Command("git", "diff", "--no-index", "--full-index", "--", os.DevNull, p)
+	execCmd.Dir = toplevel
+	var stderr bytes.Buffer
+	execCmd.Stderr = &stderr
+	out, err := execCmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 { // the files differ
+		err = nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("git diff --no-index %s: %v: %s", p, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// isAncestor reports whether the given ancestor commit is reachable from sha
+func isAncestor(dir, ancestor, sha string) (bool, error) {
+	execCmd := exec.Command("git", "merge-base", "--is-ancestor", ancestor, sha)
+	execCmd.Dir = dir
+	var stderr bytes.Buffer
+	execCmd.Stderr = &stderr
+	err := execCmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("git merge-base --is-ancestor %s %s: %v: %s", ancestor, sha, err, strings.TrimSpace(stderr.String()))
+	}
+	return true, nil
+}
+
+// workingTreeVersion names a version after the branch and the short hash of the given commit, followed by a
+// short hash of the diff if any (e.g: main-1a2b3c4-dirty-5f3e2a1b)
+func workingTreeVersion(dir, head, branch, diff string) (string, error) {
+	short, err := git(dir, "rev-parse", "--short", head)
+	if err != nil {
+		return "", err
+	}
+	version := short
+	if branch = strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(branch), "-"), "-."); branch != "" {
+		version = fmt.Sprintf("%s-%s", branch, short)
+	}
+	if diff != "" {
+		sum := sha256.Sum256([]byte(diff))
+		version = fmt.Sprintf("%s-dirty-%x", version, sum[:4])
+	}
+	return version, nil
+}
+
+// commitInfo returns the committer date and the subject of the given commit
+func commitInfo(dir, sha string) (time.Time, string, error) {
+	out, err := git(dir, "log", "-1", "--format=%cI%n%s", sha)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	lines := strings.SplitN(out, "\n", 2)
+	committedAt, err := time.Parse(time.RFC3339, lines[0])
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	if len(lines) == 1 {
+		return committedAt, "", nil
+	}
+	return committedAt, lines[1], nil
+}
+
+// exportCommit extracts the given paths of the repository on toplevel, as of the given commit, into a new temporary
+// dir that keeps their layout relative to toplevel. The files are the blobs of its tree as they are, so the export
+// matches the listing of listCommit (unlike git archive, which applies the export attributes)
+func exportCommit(toplevel, sha string, paths []string) (string, error) {
+	c, err := listCommit(toplevel, sha, paths)
+	if err != nil {
+		return "", errors.Wrap(err, "listCommit")
+	}
+	dst, err := os.MkdirTemp("", "bencher-export-")
+	if err != nil {
+		return "", err
+	}
+	err = c.export(dst)
+	if err != nil {
+		os.RemoveAll(dst)
+		return "", err
+	}
+	return dst, nil
+}
+
+// commitFS lists the files of a commit from its tree, so they are never written. Its paths are the ones they'd
+// have on the working tree of toplevel
+type commitFS struct {
+	toplevel string
+	files    map[string]commitFile
+	dirs     map[string][]fs.DirEntry
+}
+
+type commitFile struct {
+	name   string
+	mode   fs.FileMode
+	size   int64
+	object string
+}
+
+func (f commitFile) Name() string       { return f.name }
+func (f commitFile) Size() int64        { return f.size }
+func (f commitFile) Mode() fs.FileMode  { return f.mode }
+func (f commitFile) ModTime() time.Time { return time.Time{} }
+func (f commitFile) IsDir() bool        { return f.mode.IsDir() }
+func (f commitFile) Sys() interface{}   { return nil }
+
+// listCommit lists the given paths of the repository on toplevel, as of the given commit
+func listCommit(toplevel, sha string, paths []string) (*commitFS, error) {
+	var pathspecs []string
+	for _, p := range paths {
+		rel, err := repoPath(toplevel, p)
+		if err != nil {
+			return nil, err
+		}
+		pathspecs = append(pathspecs, rel)
+	}
+	out, err := git(toplevel, append([]string{"ls-tree", "-r", "-l", "-z", sha, "--"}, pathspecs...)...)
+	if err != nil {
+		return nil, err
+	}
+	c := &commitFS{toplevel: toplevel, files: map[string]commitFile{}, dirs: map[string][]fs.DirEntry{}}
+	for _, line := range strings.Split(out, "\x00") {
+		tab := strings.IndexByte(line, '\t')
+		if tab < 0 {
+			continue
+		}
+		fields := strings.Fields(line[:tab]) // <mode> <type> <object> <size>
+		if len(fields) != 4 || fields[1] != "blob" {
+			continue // submodules aren't exported either
+		}
+		f := commitFile{object: fields[2], mode: 0644}
+		switch fields[0] {
+		case "100755":
+			f.mode = 0755
+		case "120000":
+			f.mode = fs.ModeSymlink | 0777
+		}
+		f.size, err = strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "ls-tree size of %s", line[tab+1:])
+		}
+		c.add(filepath.Join(toplevel, filepath.FromSlash(line[tab+1:])), f)
+	}
+	return c, nil
+}
+
+// add adds the file along with the dirs leading to it
+func (c *commitFS) add(p string, f commitFile) {
+	for {
+		f.name = filepath.Base(p)
+		c.files[p] = f
+		dir := filepath.Dir(p)
+		_, seen := c.dirs[dir]
+		c.dirs[dir] = append(c.dirs[dir], fs.FileInfoToDirEntry(f))
+		if seen || dir == c.toplevel {
+			return
+		}
+		p, f = dir, commitFile{mode: fs.ModeDir | 0755}
+	}
+}
+
+func (c *commitFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, ok := c.dirs[filepath.Clean(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, k int) bool { return entries[i].Name() < entries[k].Name() })
+	return entries, nil
+}
+
+func (c *commitFS) ReadFile(name string) ([]byte, error) {
+	f, ok := c.files[filepath.Clean(name)]
+	if !ok || f.mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+	out, err := git(c.toplevel, "cat-file", "blob", f.object)
+	return []byte(out), err
+}
+
+func (c *commitFS) Lstat(name string) (fs.FileInfo, error) {
+	f, ok := c.files[filepath.Clean(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrNotExist}
+	}
+	return f, nil
+}
+
+// showFile returns the content of the given file of the repository on toplevel, as of the given commit
+func showFile(toplevel, sha, p string) ([]byte, error) {
+	rel, err := repoPath(toplevel, p)
+	if err != nil {
+		return nil, err
+	}
+	object := fmt.Sprintf("%s:%s", sha, rel)
+	if _, err := git(toplevel, "cat-file", "-e", object); err != nil {
+		return nil, os.ErrNotExist
+	}
+	out, err := git(toplevel, "show", object)
+	return []byte(out), err
+}
+
+// repoPath returns the given path relative to the toplevel of its repository, slash separated
+func repoPath(toplevel, p string) (string, error) {
+	rel, err := filepath.Rel(toplevel, p)
+	if err != nil {
+		return "", err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return "", fmt.Errorf("%s is out of the repository, it can't be exported from a commit", p)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// export writes the files to dst, reading their blobs from a single git cat-file
+func (c *commitFS) export(dst string) error {
+	execCmd := exec.Command("git", "cat-file", "--batch")
+	execCmd.Dir = c.toplevel
+	var stderr bytes.Buffer
+	execCmd.Stderr = &stderr
+	in, err := execCmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	out, err := execCmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	err = execCmd.Start()
+	if err != nil {
+		return errors.Wrap(err, "git cat-file")
+	}
+	err = c.writeFiles(dst, in, bufio.NewReader(out))
+	in.Close()
+	if err != nil {
+		execCmd.Process.Kill() // it may be blocked writing a blob that won't be read
+	}
+	if waitErr := execCmd.Wait(); waitErr != nil && err == nil {
+		err = fmt.Errorf("git cat-file --batch: %v: %s", waitErr, strings.TrimSpace(stderr.String()))
+	}
+	return err
+}
+
+func (c *commitFS) writeFiles(dst string, in io.Writer, out *bufio.Reader) error {
+	for p, f := range c.files {
+		if f.mode.IsDir() {
+			continue
+		}
+		_, err := fmt.Fprintln(in, f.object)
+		if err != nil {
+			return err
+		}
+		header, err := out.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(header) // <object> blob <size>
+		if len(fields) != 3 {
+			return fmt.Errorf("git cat-file %s: %s", f.object, strings.TrimSpace(header))
+		}
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return errors.Wrapf(err, "git cat-file size of %s", f.object)
+		}
+		rel, _ := filepath.Rel(c.toplevel, p)
+		target := filepath.Join(dst, rel)
+		err = os.MkdirAll(filepath.Dir(target), os.ModePerm)
+		if err != nil {
+			return err
+		}
+		if f.mode&fs.ModeSymlink != 0 {
+			link := make([]byte, size)
+			_, err = io.ReadFull(out, link)
+			if err == nil {
+				err = os.Symlink(string(link), target)
+			}
+		} else {
+			var file *os.File
+			file, err = os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, f.mode.Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.CopyN(file, out, size)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			return errors.Wrapf(err, "write %s", rel)
+		}
+		_, err = out.ReadByte() // the newline after the blob
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
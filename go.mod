@@ -5,10 +5,12 @@ go 1.17
 replace github.com/Sirupsen/logrus => github.com/sirupsen/logrus v1.6.0
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/docker/docker v20.10.12+incompatible
 	github.com/mitchellh/cli v1.1.2
 	github.com/pkg/errors v0.9.1
 	go.etcd.io/bbolt v1.3.6
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -21,7 +23,7 @@ require (
 	github.com/containerd/containerd v1.5.8 // indirect
 	github.com/docker/distribution v2.7.1+incompatible // indirect
 	github.com/docker/go-connections v0.4.0 // indirect
-	github.com/docker/go-units v0.4.0 // indirect
+	github.com/docker/go-units v0.4.0
 	github.com/fatih/color v1.7.0 // indirect
 	github.com/gogo/protobuf v1.3.2 // indirect
 	github.com/golang/protobuf v1.5.0 // indirect
@@ -12,19 +12,24 @@ const (
 
 	RunnerRootPath = "/bencher"
 
-	db  = "db"
-	pid = "pid"
+	db    = "db"
+	pid   = "pid"
+	quiet = "quiet"
 )
 
 var (
 	// host paths
 	HostRootPath       = fmt.Sprintf("%s/.bencher", os.Getenv("HOME"))
 	HostVersionsPath   = fmt.Sprintf("%s/versions", HostRootPath)
+	HostBlobsPath      = fmt.Sprintf("%s/blobs", HostRootPath)
+	HostManifestsPath  = fmt.Sprintf("%s/manifests", HostRootPath)
 	HostServerRootPath = fmt.Sprintf("%s/server", HostRootPath)
 	HostDBFilename     = fmt.Sprintf("%s/%s", HostServerRootPath, db)
 	HostPIDFilename    = fmt.Sprintf("%s/%s", HostServerRootPath, pid)
+	HostQuietFilename  = fmt.Sprintf("%s/%s", HostServerRootPath, quiet)
 
 	// server paths
-	ServerDBFilename  = fmt.Sprintf("%s/%s", ServerRootPath, db)
-	ServerPIDFilename = fmt.Sprintf("%s/%s", ServerRootPath, pid)
+	ServerDBFilename    = fmt.Sprintf("%s/%s", ServerRootPath, db)
+	ServerPIDFilename   = fmt.Sprintf("%s/%s", ServerRootPath, pid)
+	ServerQuietFilename = fmt.Sprintf("%s/%s", ServerRootPath, quiet) // holds the version waiting for a quiet host
 )
@@ -1,15 +1,22 @@
 package bencher
 
 import (
+	"archive/tar"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-units"
 	"github.com/pkg/errors"
 	"go.etcd.io/bbolt"
 )
@@ -18,9 +25,110 @@ type Job struct {
 	Stdout  string
 	Stderr  string
 	Version string
+	Limits  Limits
+	Env     []string // user-given variables of the runner
+	Image   string   // runner image, targeted by digest when possible
+	Cmd     []string
+	Dir     string // working dir, relative to the module root
+	// Setup are the shell steps run on the runner before Cmd, whose combined output is kept on SetupOutput
+	Setup       []string `json:",omitempty"`
+	SetupOutput string   `json:",omitempty"`
+	// Network is the docker network the runner is attached to, NetworkNone by default as the dependencies are vendored
+	Network string `json:",omitempty"`
+	// Priority orders the job in the queue (see Enqueue)
+	Priority string `json:",omitempty"`
+	// Profile is the profile of the config files the job was created with, if any
+	Profile string `json:",omitempty"`
+	// Tags and Note describe the job, they can be edited once created (see UpdateJob)
+	Tags []string `json:",omitempty"`
+	Note string   `json:",omitempty"`
+	// Timeout is the time the runner is given to complete, it's capped by MaxTimeout
+	Timeout time.Duration `json:",omitempty"`
+	// Quiet is the gate the host must pass before the runner is started, HostLoad is what was measured on it
+	Quiet    QuietGate
+	HostLoad *HostLoad `json:",omitempty"`
+	// Sidecars are started on the private network of the job before the runner, which is given their addresses
+	Sidecars []Sidecar `json:",omitempty"`
+	Commit   string    `json:",omitempty"` // git commit the snapshot was exported from
+	// Head is the git commit the working tree was on when snapshotted, and Diff its uncommitted changes against it
+	Head string `json:",omitempty"`
+	Diff string `json:",omitempty"`
+	// CommitTime and CommitSubject describe the commit, so the jobs can be sorted in commit order
+	CommitTime    time.Time `json:",omitempty"`
+	CommitSubject string    `json:",omitempty"`
+	// CompileTime is the time the runner took until the first output of the benchmarks
+	CompileTime time.Duration
+	// Base is the version whose snapshot is shared by the variants of a matrix, which differ on the Variant env
+	Base    string   `json:",omitempty"`
+	Variant []string `json:",omitempty"`
+	// Parent is the version a round belongs to, whose output is appended with the round one when saved.
+	// The rounds that didn't complete are kept on FailedRounds instead, along with their status
+	Parent       string   `json:",omitempty"`
+	FailedRounds []string `json:",omitempty"`
+	// Reason is the cause of an abnormal termination (e.g: StatusOOMKilled), it takes precedence over the output
+	Reason string
 }
 
-var KeyJob = []byte("jobs")
+// QuietGate are the thresholds the host must stay under For a period before starting the runner, disabled if For is zero
+type QuietGate struct {
+	For  time.Duration `json:",omitempty"`
+	Load float64       `json:",omitempty"` // 1m load average
+	CPU  float64       `json:",omitempty"` // percent of the host cpu used by the containers not run by bencher
+}
+
+const (
+	DefaultQuietLoad = 1
+	DefaultQuietCPU  = 10
+)
+
+// HostLoad is the load of the host measured right before starting the runner
+type HostLoad struct {
+	Load      float64
+	OthersCPU *float64      // percent of the host cpu used by the containers not run by bencher, only measured by the gate
+	Waited    time.Duration // time waited for the host to be quiet
+}
+
+func (l HostLoad) String() string {
+	s := fmt.Sprintf("load %.2f", l.Load)
+	if l.OthersCPU != nil {
+		s = fmt.Sprintf("%s, others cpu %.1f%%", s, *l.OthersCPU)
+	}
+	if l.Waited > 0 {
+		s = fmt.Sprintf("%s, waited %s", s, l.Waited)
+	}
+	return s
+}
+
+// Limits are the resources the runner container is constrained to
+type Limits struct {
+	CPUs      float64
+	Memory    int64
+	PidsLimit int64
+	Cpuset    string // cores the runner is pinned to (e.g: 2-5)
+}
+
+const (
+	StatusOOMKilled   = "oom-killed"
+	StatusSetupFailed = "setup-failed"
+	StatusTimedOut    = "timed-out"
+)
+
+const NetworkNone = "none"
+
+// MaxTimeout caps the timeout of every job, so a deadlocked runner never blocks the queue forever
+const MaxTimeout = 24 * time.Hour
+
+const (
+	// RunnerSetupLog is the file of the runner where the output of the setup steps is written
+	RunnerSetupLog = "/tmp/bencher-setup.log"
+	// SetupFailedExitCode is the exit code of the runner when a setup step fails
+	SetupFailedExitCode = 97
+)
+
+var (
+	KeyJob     = []byte("jobs")
+	KeyPending = []byte("pending") // jobs that were created but not completed yet
+)
 
 func (j *Job) Status() (status string) {
 	if j.Stdout != "" {
@@ -29,48 +137,227 @@ func (j *Job) Status() (status string) {
 	if j.Stderr != "" {
 		status = "errored"
 	}
+	if j.Reason != "" {
+		status = j.Reason
+	}
 	return status
 }
 
+// HasTags reports whether the job is tagged with all the given tags
+func (j *Job) HasTags(tags []string) bool {
+	for _, tag := range tags {
+		found := false
+		for _, t := range j.Tags {
+			found = found || t == tag
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Snapshot is the version whose snapshot the job runs on
+func (j *Job) Snapshot() string {
+	if j.Base != "" {
+		return j.Base
+	}
+	return j.Version
+}
+
+func (l Limits) Resources() container.Resources {
+	r := container.Resources{
+		NanoCPUs:   int64(l.CPUs * 1e9),
+		Memory:     l.Memory,
+		CpusetCpus: l.Cpuset,
+	}
+	if l.PidsLimit > 0 {
+		r.PidsLimit = &l.PidsLimit
+	}
+	return r
+}
+
+func (l Limits) String() string {
+	var s []string
+	if l.CPUs > 0 {
+		s = append(s, fmt.Sprintf("cpus=%g", l.CPUs))
+	}
+	if l.Memory > 0 {
+		s = append(s, fmt.Sprintf("memory=%s", units.BytesSize(float64(l.Memory))))
+	}
+	if l.PidsLimit > 0 {
+		s = append(s, fmt.Sprintf("pids-limit=%d", l.PidsLimit))
+	}
+	if l.Cpuset != "" {
+		s = append(s, fmt.Sprintf("cpuset=%s", l.Cpuset))
+	}
+	if len(s) == 0 {
+		return "none"
+	}
+	return strings.Join(s, " ")
+}
+
+// SchedulerCpuset is the core the scheduler is pinned to while the runner is, the first one of the host out of the
+// runner cpuset. It's empty if the runner isn't pinned or takes all the cores
+func (l Limits) SchedulerCpuset(ncpu int) string {
+	if l.Cpuset == "" {
+		return ""
+	}
+	cores, err := ParseCpuset(l.Cpuset)
+	if err != nil {
+		return ""
+	}
+	for core := 0; core < ncpu; core++ {
+		if !cores[core] {
+			return strconv.Itoa(core)
+		}
+	}
+	return ""
+}
+
+// ParseCpuset parses the list format used by cgroups (e.g: 0-2,5)
+func ParseCpuset(cpuset string) (map[int]bool, error) {
+	cores := make(map[int]bool)
+	for _, part := range strings.Split(cpuset, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		from, err := strconv.Atoi(bounds[0])
+		if err != nil || from < 0 {
+			return nil, fmt.Errorf("invalid cpuset %q", cpuset)
+		}
+		to := from
+		if len(bounds) == 2 {
+			to, err = strconv.Atoi(bounds[1])
+			if err != nil || to < from {
+				return nil, fmt.Errorf("invalid cpuset %q", cpuset)
+			}
+		}
+		for core := from; core <= to; core++ {
+			cores[core] = true
+		}
+	}
+	return cores, nil
+}
+
 type DBGetter func() (*bbolt.DB, error)
 
 func (j *Job) Complete(ctx context.Context, dbGetter DBGetter, docker *client.Client) error {
-	wait, errCh := docker.ContainerWait(ctx, j.Version, container.WaitConditionNotRunning)
+	waitCtx, cancel := context.WithTimeout(ctx, j.effectiveTimeout())
+	defer cancel()
+	wait, errCh := docker.ContainerWait(waitCtx, j.Version, container.WaitConditionNotRunning)
 	select {
 	case err := <-errCh:
-		if err != nil {
+		if waitCtx.Err() != context.DeadlineExceeded {
 			return errors.Wrap(err, "wait")
 		}
-	case <-wait:
-		err := j.Collect(ctx, docker)
+		noGrace := time.Duration(0)
+		err = docker.ContainerStop(ctx, j.Version, &noGrace)
 		if err != nil {
-			return errors.Wrap(err, "collect")
+			return errors.Wrap(err, "stop")
 		}
-		db, err := dbGetter()
+		j.Reason = StatusTimedOut // the partial output is kept
+	case <-wait:
+	}
+	err := j.Collect(ctx, docker)
+	if err != nil {
+		return errors.Wrap(err, "collect")
+	}
+	err = j.Inspect(ctx, docker)
+	if err != nil {
+		return errors.Wrap(err, "inspect")
+	}
+	db, err := dbGetter()
+	if err != nil {
+		return errors.Wrap(err, "dbGetter")
+	}
+	err = j.Save(ctx, db)
+	db.Close()
+	if err != nil {
+		return errors.Wrap(err, "save")
+	}
+	err = j.Teardown(ctx, docker)
+	if err != nil {
+		return errors.Wrap(err, "teardown")
+	}
+	return nil
+}
+
+func (j *Job) effectiveTimeout() time.Duration {
+	if j.Timeout <= 0 || j.Timeout > MaxTimeout {
+		return MaxTimeout
+	}
+	return j.Timeout
+}
+
+func (j *Job) Teardown(ctx context.Context, docker *client.Client) error {
+	err := docker.ContainerRemove(ctx, j.Version, types.ContainerRemoveOptions{})
+	if err != nil {
+		return err
+	}
+	if len(j.Sidecars) == 0 {
+		return nil
+	}
+	return errors.Wrap(RemoveSidecars(ctx, docker, j.Version), "RemoveSidecars")
+}
+
+func (j *Job) Save(ctx context.Context, db *bbolt.DB) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(KeyJob)
 		if err != nil {
-			return errors.Wrap(err, "dbGetter")
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		bPending := tx.Bucket(KeyPending)
+		if bPending != nil {
+			if data := bPending.Get([]byte(j.Version)); data != nil {
+				pending := &Job{}
+				if json.Unmarshal(data, pending) == nil { // they could be edited while running
+					j.Tags, j.Note = pending.Tags, pending.Note
+				}
+			}
+		}
+		saved := j
+		if j.Parent != "" {
+			saved, err = j.appendToParent(b)
+			if err != nil {
+				return errors.Wrap(err, "appendToParent")
+			}
 		}
-		err = j.Save(ctx, db)
-		db.Close()
+		data, err := json.Marshal(saved)
 		if err != nil {
-			return errors.Wrap(err, "save")
+			return err
 		}
-		err = j.Teardown(ctx, docker)
+		err = b.Put([]byte(saved.Version), data)
 		if err != nil {
-			return errors.Wrap(err, "teardown")
+			return err
 		}
-		return nil
-	}
-	return nil
+		if bPending == nil {
+			return nil
+		}
+		return bPending.Delete([]byte(j.Version))
+	})
 }
 
-func (j *Job) Teardown(ctx context.Context, docker *client.Client) error {
-	return docker.ContainerRemove(ctx, j.Version, types.ContainerRemoveOptions{})
+func (j *Job) appendToParent(bJobs *bbolt.Bucket) (*Job, error) {
+	data := bJobs.Get([]byte(j.Parent))
+	if data == nil {
+		return nil, fmt.Errorf("job %s not found", j.Parent)
+	}
+	parent := &Job{}
+	err := json.Unmarshal(data, parent)
+	if err != nil {
+		return nil, err
+	}
+	if status := j.Status(); status != "done" { // its output is left out, so the samples of the parent are kept as they are
+		parent.FailedRounds = append(parent.FailedRounds, fmt.Sprintf("%s: %s", j.Version, status))
+		return parent, nil
+	}
+	parent.Stdout += j.Stdout
+	return parent, nil
 }
 
-func (j *Job) Save(ctx context.Context, db *bbolt.DB) error {
+// SavePending stores the job spec so the server can pick it up when the job is ran
+func (j *Job) SavePending(db *bbolt.DB) error {
 	return db.Update(func(tx *bbolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists(KeyJob)
+		b, err := tx.CreateBucketIfNotExists(KeyPending)
 		if err != nil {
 			return fmt.Errorf("create bucket: %s", err)
 		}
@@ -82,6 +369,138 @@ func (j *Job) Save(ctx context.Context, db *bbolt.DB) error {
 	})
 }
 
+// UpdateJob applies fn to the given version, either completed or pending, and saves it.
+// It reports whether the version was found
+func UpdateJob(db *bbolt.DB, version string, fn func(*Job)) (found bool, err error) {
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, key := range [][]byte{KeyJob, KeyPending} {
+			b := tx.Bucket(key)
+			if b == nil {
+				continue
+			}
+			data := b.Get([]byte(version))
+			if data == nil {
+				continue
+			}
+			j := &Job{}
+			err := json.Unmarshal(data, j)
+			if err != nil {
+				return err
+			}
+			fn(j)
+			data, err = json.Marshal(j)
+			if err != nil {
+				return err
+			}
+			found = true
+			return b.Put([]byte(version), data)
+		}
+		return nil
+	})
+	return found, err
+}
+
+// GetPending returns the spec of the given version, or a bare job in case it wasn't found
+func GetPending(db *bbolt.DB, version string) (j *Job, err error) {
+	err = db.View(func(tx *bbolt.Tx) error {
+		j, err = getPending(tx, version)
+		return err
+	})
+	return j, err
+}
+
+func (j *Job) Inspect(ctx context.Context, docker *client.Client) error {
+	info, err := docker.ContainerInspect(ctx, j.Version)
+	if err != nil {
+		return err
+	}
+	if info.State == nil {
+		return nil
+	}
+	if info.State.OOMKilled {
+		j.Reason = StatusOOMKilled
+	}
+	startedAt, err := time.Parse(time.RFC3339Nano, info.State.StartedAt)
+	if err != nil {
+		return errors.Wrap(err, "parse StartedAt")
+	}
+	if len(j.Setup) > 0 {
+		if info.State.ExitCode == SetupFailedExitCode && j.Reason == "" {
+			j.Reason = StatusSetupFailed
+		}
+		var setupDoneAt time.Time
+		setupDoneAt, err = j.collectSetup(ctx, docker)
+		if err != nil {
+			return errors.Wrap(err, "collectSetup")
+		}
+		if j.Reason == StatusSetupFailed {
+			return nil
+		}
+		if !setupDoneAt.IsZero() {
+			startedAt = setupDoneAt // the compilation starts once the setup is done
+		}
+	}
+	return errors.Wrap(j.measureCompile(ctx, docker, startedAt), "measureCompile")
+}
+
+// collectSetup reads the output of the setup steps from the runner, returning the time they were done at
+func (j *Job) collectSetup(ctx context.Context, docker *client.Client) (time.Time, error) {
+	rc, stat, err := docker.CopyFromContainer(ctx, j.Version, RunnerSetupLog)
+	if client.IsErrNotFound(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer rc.Close()
+	tr := tar.NewReader(rc)
+	_, err = tr.Next()
+	if err != nil {
+		return time.Time{}, err
+	}
+	out, err := io.ReadAll(tr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	j.SetupOutput = string(out)
+	return stat.Mtime, nil
+}
+
+// measureCompile takes the time elapsed since the start of the runner until its first output line,
+// which the benchmarks print once all the packages are compiled
+func (j *Job) measureCompile(ctx context.Context, docker *client.Client, startedAt time.Time) error {
+	out, err := docker.ContainerLogs(ctx, j.Version, types.ContainerLogsOptions{ShowStdout: true, Timestamps: true})
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	logs := &bytes.Buffer{}
+	_, err = stdcopy.StdCopy(logs, io.Discard, out)
+	if err != nil {
+		return err
+	}
+	ts := strings.SplitN(logs.String(), " ", 2)[0]
+	if ts == "" {
+		return nil
+	}
+	firstOutAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return err
+	}
+	j.CompileTime = firstOutAt.Sub(startedAt).Round(time.Millisecond)
+	return nil
+}
+
+// SortByCommit sorts the jobs by the time of their commits, leaving the ones without commit at the end
+func SortByCommit(jobs []*Job) {
+	sort.SliceStable(jobs, func(i, k int) bool {
+		if jobs[k].CommitTime.IsZero() {
+			return !jobs[i].CommitTime.IsZero()
+		}
+		return !jobs[i].CommitTime.IsZero() && jobs[i].CommitTime.Before(jobs[k].CommitTime)
+	})
+}
+
 // todo: collect in the meantime with follow and tail so it can be obtained through get
 func (j *Job) Collect(ctx context.Context, docker *client.Client) error {
 	out, err := docker.ContainerLogs(ctx, j.Version, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true})
@@ -99,7 +518,11 @@ func (j *Job) Collect(ctx context.Context, docker *client.Client) error {
 }
 
 func (j *Job) RunNow(ctx context.Context, dbGetter DBGetter, docker *client.Client) error {
-	err := docker.ContainerStart(ctx, j.Version, types.ContainerStartOptions{})
+	err := j.StartSidecars(ctx, docker)
+	if err != nil {
+		return errors.Wrap(j.abort(ctx, dbGetter, docker, StatusSidecarFailed, err), "abort")
+	}
+	err = docker.ContainerStart(ctx, j.Version, types.ContainerStartOptions{})
 	if err != nil {
 		return errors.Wrap(err, "start")
 	}
@@ -109,3 +532,18 @@ func (j *Job) RunNow(ctx context.Context, dbGetter DBGetter, docker *client.Clie
 	}
 	return nil
 }
+
+// abort saves the job as terminated by the given reason without running it
+func (j *Job) abort(ctx context.Context, dbGetter DBGetter, docker *client.Client, reason string, cause error) error {
+	j.Reason, j.Stderr = reason, cause.Error()
+	db, err := dbGetter()
+	if err != nil {
+		return errors.Wrap(err, "dbGetter")
+	}
+	err = j.Save(ctx, db)
+	db.Close()
+	if err != nil {
+		return errors.Wrap(err, "save")
+	}
+	return errors.Wrap(j.Teardown(ctx, docker), "teardown")
+}
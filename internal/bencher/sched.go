@@ -1,5 +1,114 @@
 package bencher
 
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go.etcd.io/bbolt"
+)
+
 var (
 	KeySched = []byte("sched")
 )
+
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+var priorityRanks = map[string]int{PriorityHigh: 0, "": 1, PriorityNormal: 1, PriorityLow: 2}
+
+// IsPriority reports whether the given priority is valid
+func IsPriority(priority string) bool {
+	_, ok := priorityRanks[priority]
+	return ok
+}
+
+// Queue returns the scheduled versions, in the order they're run
+func Queue(tx *bbolt.Tx) []string {
+	b := tx.Bucket(KeySched)
+	if b == nil {
+		return nil
+	}
+	var queue []string
+	for _, version := range strings.Split(string(b.Get(KeySched)), ",") {
+		if version != "" {
+			queue = append(queue, version)
+		}
+	}
+	return queue
+}
+
+// PutQueue replaces the scheduled versions
+func PutQueue(tx *bbolt.Tx, queue []string) error {
+	b, err := tx.CreateBucketIfNotExists(KeySched)
+	if err != nil {
+		return fmt.Errorf("create bucket: %s", err)
+	}
+	var sched string
+	for _, version := range queue {
+		sched += version + ","
+	}
+	return b.Put(KeySched, []byte(sched))
+}
+
+// Enqueue schedules the job right after the last queued one of the same or higher priority, so the order given
+// by MoveInQueue is kept
+func Enqueue(tx *bbolt.Tx, j *Job) error {
+	queue := Queue(tx)
+	i := 0
+	for k, version := range queue {
+		other, err := getPending(tx, version)
+		if err != nil {
+			return err
+		}
+		if priorityRanks[other.Priority] <= priorityRanks[j.Priority] {
+			i = k + 1
+		}
+	}
+	queue = append(queue[:i], append([]string{j.Version}, queue[i:]...)...)
+	return PutQueue(tx, queue)
+}
+
+// MoveInQueue moves the given version right before the other one, or to the top of the queue if other is empty
+func MoveInQueue(tx *bbolt.Tx, version, other string) error {
+	queue := Queue(tx)
+	from := indexOf(queue, version)
+	if from < 0 {
+		return fmt.Errorf("%s is not scheduled", version)
+	}
+	queue = append(queue[:from], queue[from+1:]...)
+	to := 0
+	if other != "" {
+		to = indexOf(queue, other)
+		if to < 0 {
+			return fmt.Errorf("%s is not scheduled", other)
+		}
+	}
+	queue = append(queue[:to], append([]string{version}, queue[to:]...)...)
+	return PutQueue(tx, queue)
+}
+
+func indexOf(sl []string, s string) int {
+	for i, ss := range sl {
+		if ss == s {
+			return i
+		}
+	}
+	return -1
+}
+
+func getPending(tx *bbolt.Tx, version string) (*Job, error) {
+	j := &Job{Version: version}
+	b := tx.Bucket(KeyPending)
+	if b == nil {
+		return j, nil
+	}
+	data := b.Get([]byte(version))
+	if data == nil {
+		return j, nil
+	}
+	return j, json.Unmarshal(data, j)
+}
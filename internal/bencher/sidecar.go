@@ -0,0 +1,102 @@
+package bencher
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+)
+
+// Sidecar is a service the runner depends on (e.g: a database), reachable on the network of the job by its name
+type Sidecar struct {
+	Name        string
+	Image       string
+	Env         []string `json:",omitempty"`
+	Healthcheck string   `json:",omitempty"` // shell command that succeeds once the sidecar is ready
+	Addr        string   `json:",omitempty"` // address given to the runner
+}
+
+const (
+	StatusSidecarFailed = "sidecar-failed"
+
+	// VersionLabel is the label that ties a sidecar to the version of its job
+	VersionLabel = ContainersLabel + ".version"
+
+	sidecarReadyTimeout = 2 * time.Minute
+)
+
+// NetworkName is the private network shared by the runner of the given version and its sidecars
+func NetworkName(version string) string {
+	return fmt.Sprintf("%s_%s", ContainersLabel, version)
+}
+
+// SidecarContainerName is the container name of the given sidecar of a version
+func SidecarContainerName(version, name string) string {
+	return fmt.Sprintf("%s_%s", version, name)
+}
+
+// StartSidecars starts the sidecars of the job and waits until all of them are ready
+func (j *Job) StartSidecars(ctx context.Context, docker *client.Client) error {
+	for _, sc := range j.Sidecars {
+		err := docker.ContainerStart(ctx, SidecarContainerName(j.Version, sc.Name), types.ContainerStartOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "start[%s]", sc.Name)
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, sidecarReadyTimeout)
+	defer cancel()
+	for _, sc := range j.Sidecars {
+		err := waitSidecar(ctx, docker, SidecarContainerName(j.Version, sc.Name))
+		if err != nil {
+			return errors.Wrapf(err, "waitSidecar[%s]", sc.Name)
+		}
+	}
+	return nil
+}
+
+// waitSidecar waits until the sidecar is healthy, or just running when it has no healthcheck
+func waitSidecar(ctx context.Context, docker *client.Client, name string) error {
+	for {
+		info, err := docker.ContainerInspect(ctx, name)
+		if err != nil {
+			return err
+		}
+		if info.State != nil {
+			if !info.State.Running {
+				return fmt.Errorf("exited with code %d", info.State.ExitCode)
+			}
+			if info.State.Health == nil || info.State.Health.Status == types.Healthy {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("not ready after %s", sidecarReadyTimeout)
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// RemoveSidecars removes the sidecars of the given version along with its network
+func RemoveSidecars(ctx context.Context, docker *client.Client, version string) error {
+	args := filters.NewArgs(filters.Arg("label", fmt.Sprintf("%s=%s", VersionLabel, version)))
+	containers, err := docker.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: args})
+	if err != nil {
+		return errors.Wrap(err, "ContainerList")
+	}
+	for _, c := range containers {
+		err = docker.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{Force: true, RemoveVolumes: true})
+		if err != nil {
+			return errors.Wrap(err, "ContainerRemove")
+		}
+	}
+	err = docker.NetworkRemove(ctx, NetworkName(version))
+	if client.IsErrNotFound(err) {
+		return nil
+	}
+	return errors.Wrap(err, "NetworkRemove")
+}
@@ -13,12 +13,20 @@ func main() {
 	c := cli.NewCLI("app", "1.0.0")
 	c.Args = os.Args[1:]
 	c.Commands = map[string]cli.CommandFactory{
-		"run":     prepareRun,
-		"get":     prepareGet,
-		"ls":      prepareGet,
-		"restore": prepareRestore,
-		"rm":      prepareRm,
-		"cmp":     prepareCmp,
+		"run":      prepareRun,
+		"get":      prepareGet,
+		"ls":       prepareGet,
+		"restore":  prepareRestore,
+		"rm":       prepareRm,
+		"cmp":      prepareCmp,
+		"ab":       prepareAb,
+		"cache":    prepareCache,
+		"du":       prepareDu,
+		"bisect":   prepareBisect,
+		"backfill": prepareBackfill,
+		"queue":    prepareQueue,
+		"tag":      prepareTag,
+		"note":     prepareNote,
 	}
 	c.HiddenCommands = []string{"ls"} // alias of get
 	rand.Seed(time.Now().Unix())
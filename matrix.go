@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// matrixAxis is a variable whose values are combined with the other axes of the matrix
+type matrixAxis struct {
+	key    string
+	values []string
+}
+
+// matrixSep separates the values of an axis, as go settings never use it (unlike commas, e.g: GOEXPERIMENT=arenas,boringcrypto)
+const matrixSep = "|"
+
+func parseMatrix(flags []string) ([]matrixAxis, error) {
+	var matrix []matrixAxis
+	for _, flag := range flags {
+		kv := strings.SplitN(flag, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid --matrix value %q, must be KEY=VAL1%sVAL2", flag, matrixSep)
+		}
+		matrix = append(matrix, matrixAxis{key: kv[0], values: strings.Split(kv[1], matrixSep)})
+	}
+	return matrix, nil
+}
+
+// expandMatrix returns every combination of the axes, as env assignments
+func expandMatrix(matrix []matrixAxis) [][]string {
+	variants := [][]string{nil}
+	for _, axis := range matrix {
+		var expanded [][]string
+		for _, variant := range variants {
+			for _, val := range axis.values {
+				expanded = append(expanded, append(variant[:len(variant):len(variant)], fmt.Sprintf("%s=%s", axis.key, val)))
+			}
+		}
+		variants = expanded
+	}
+	return variants
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9_.-]+`)
+
+// variantVersion names the variant after its assignments, keeping it valid as a container name
+func variantVersion(version string, variant []string) string {
+	name := version
+	for _, assignment := range variant {
+		name += "." + invalidNameChars.ReplaceAllString(strings.ToLower(strings.Replace(assignment, "=", "-", 1)), "_")
+	}
+	return name
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mitchellh/cli"
+	"github.com/pkg/errors"
+	"github.com/schattian/bencher/internal/bencher"
+	"go.etcd.io/bbolt"
+)
+
+type queueCmd struct{}
+
+func prepareQueue() (cli.Command, error) {
+	return &queueCmd{}, nil
+}
+
+func (cmd *queueCmd) Run(args []string) int {
+	if len(args) == 0 || args[0] != "mv" {
+		return cli.RunResultHelp
+	}
+	fs := newFlagSet("queue mv")
+	before := fs.String("before", "", "")
+	top := fs.Bool("top", false, "")
+	args, ok := parseInterspersed(fs, args[1:])
+	if !ok || len(args) != 1 || (*before == "") == !*top {
+		return cli.RunResultHelp
+	}
+	err := cmd.mv(args[0], *before)
+	if err != nil {
+		fmt.Printf("err %v", err)
+		return 1
+	}
+	return 0
+}
+
+func (cmd *queueCmd) mv(version, before string) error {
+	db, err := initDB()
+	if err != nil {
+		return errors.Wrap(err, "initDB")
+	}
+	defer db.Close()
+	var queue []string
+	err = db.Update(func(tx *bbolt.Tx) error {
+		err := bencher.MoveInQueue(tx, version, before)
+		queue = bencher.Queue(tx)
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "MoveInQueue")
+	}
+	for i, v := range queue {
+		fmt.Printf("#%d %s\n", i, v)
+	}
+	return nil
+}
+
+func (cmd *queueCmd) Synopsis() string {
+	return `reorder the scheduled versions`
+}
+
+func (cmd *queueCmd) Help() string {
+	return `Usage: bencher queue mv <version> --before <other> | --top
+
+Move a scheduled version right before the <other> one, or to the top of the queue so it's the next to run.
+The versions scheduled later are queued after the ones of the same or higher priority (see "bencher run --priority").
+Use "bencher get" to see the order of the queue`
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"fmt"
-	"os/exec"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/mitchellh/cli"
+	"github.com/pkg/errors"
 	"github.com/schattian/bencher/internal/bencher"
 )
 
@@ -15,19 +18,54 @@ func prepareRestore() (cli.Command, error) {
 }
 
 func (cmd *restoreCmd) Run(args []string) int {
-	if len(args) != 2 {
+	fs := newFlagSet("restore")
+	args, ok := parseInterspersed(fs, args)
+	if !ok || len(args) != 2 {
 		return cli.RunResultHelp
 	}
 	version, dst := args[0], args[1]
-	execCmd := exec.Command("rsync", "-a", "--exclude", "vendor", fmt.Sprintf("%s/%s/", bencher.HostVersionsPath, version), dst)
-	err := execCmd.Run()
+	m, err := readManifest(version)
+	if os.IsNotExist(err) {
+		err = restoreCopy(version, dst)
+		if err != nil {
+			fmt.Printf("err restoreCopy: %v\n", err)
+			return 1
+		}
+		return 0
+	}
 	if err != nil {
-		fmt.Printf("err rsync: %v\n", err)
+		fmt.Printf("err readManifest: %v\n", err)
+		return 1
+	}
+	err = materialize(m, dst, false, true)
+	if err != nil {
+		fmt.Printf("err materialize: %v\n", err)
 		return 1
 	}
 	return 0
 }
 
+// restoreCopy restores versions created before the blobs store by copying them
+func restoreCopy(version, dst string) error {
+	versionPath := filepath.Join(bencher.HostVersionsPath, version)
+	if _, err := os.Stat(versionPath); err != nil {
+		return err
+	}
+	snapshot := newSnapshotter(versionPath, nil)
+	err := snapshot.list()
+	if err != nil {
+		return errors.Wrap(err, "snapshot.list")
+	}
+	var entries []snapshotEntry
+	for _, e := range snapshot.entries {
+		if !strings.HasPrefix(e.path, "vendor/") {
+			entries = append(entries, e)
+		}
+	}
+	snapshot.entries = entries
+	return errors.Wrap(snapshot.copyTo(dst), "snapshot.copyTo")
+}
+
 func (cmd *restoreCmd) Synopsis() string {
 	return `restore the local copy of the given version to the given dir`
 }
@@ -35,5 +73,6 @@ func (cmd *restoreCmd) Synopsis() string {
 func (cmd *restoreCmd) Help() string {
 	return `Usage: bencher restore <version> <destination>
 
-You can either restore the version on a new dir or to the repo you've been working on by pointing <destination> to its root path`
+You can either restore the version on a new dir or to the repo you've been working on by pointing <destination> to its root path.
+Versions of a go.work workspace or with replace directives to local paths are restored with the layout of all their modules, so <destination> is their closest common dir`
 }
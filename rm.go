@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
@@ -22,19 +23,41 @@ func prepareRm() (cli.Command, error) {
 }
 
 func (cmd *rmCmd) Run(args []string) int {
-	if len(args) < 1 {
+	fs := newFlagSet("rm")
+	force := fs.Bool("f", false, "")
+	all := fs.Bool("all", false, "")
+	var tags stringsFlag
+	fs.Var(&tags, "tag", "")
+	args, ok := parseInterspersed(fs, args)
+	if !ok || (len(args) == 0 && len(tags) == 0) == !*all || (*all && len(tags) > 0) {
 		return cli.RunResultHelp
 	}
-	args, force := popFlagBoolean(args, "f")
-	if args[0] == "--all" || args[0] == "-all" {
-		err := cmd.rmAllJobs(force)
+	if *all {
+		err := cmd.rmAllJobs(*force)
 		if err != nil {
 			fmt.Printf("err rmAllJobs: %v", err)
 			return 1
 		}
 		return 0
 	}
-	err := cmd.rmJobs(force, args...)
+	if len(tags) > 0 {
+		db, err := initDB()
+		if err != nil {
+			fmt.Printf("err initDB: %v", err)
+			return 1
+		}
+		args, err = selectTagged(db, tags, args, true)
+		db.Close()
+		if err != nil {
+			fmt.Printf("err selectTagged: %v", err)
+			return 1
+		}
+		if len(args) == 0 {
+			fmt.Printf("no versions tagged %s\n", strings.Join(tags, ","))
+			return 0
+		}
+	}
+	err := cmd.rmJobs(*force, args...)
 	if err != nil {
 		if err != nil {
 			fmt.Printf("err during rmJobs: %v", err)
@@ -52,13 +75,37 @@ func (cmd *rmCmd) rmAllJobs(force bool) error {
 		}
 	}
 
-	execCmd := exec.Command("rm", "-rf", bencher.HostVersionsPath)
-	err := execCmd.Run()
+	db, err := initDB()
+	if err != nil {
+		return errors.Wrap(err, "initDB")
+	}
+	var pending []string
+	err = db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bencher.KeyPending)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(version, _ []byte) error {
+			pending = append(pending, string(version))
+			return nil
+		})
+	})
+	db.Close()
+	if err != nil {
+		return errors.Wrap(err, "pending")
+	}
+	err = rmContainers(context.Background(), pending)
+	if err != nil {
+		return errors.Wrap(err, "rmContainers")
+	}
+
+	execCmd := exec.Command("rm", "-rf", bencher.HostVersionsPath, bencher.HostManifestsPath, bencher.HostBlobsPath)
+	err = execCmd.Run()
 	if err != nil {
 		fmt.Printf("rm -rf failed: %v\n", err)
 		return errors.Wrap(err, "rm -rf")
 	}
-	db, err := initDB()
+	db, err = initDB()
 	if err != nil {
 		fmt.Printf("couldn't init db: %v", err)
 		return errors.Wrap(err, "initDB")
@@ -83,6 +130,19 @@ func (cmd *rmCmd) rmAllJobs(force bool) error {
 }
 
 func (cmd *rmCmd) rmJobs(force bool, jobs ...string) error {
+	db, err := initDB()
+	if err != nil {
+		return errors.Wrap(err, "initDB")
+	}
+	jobs, err = withVariants(db, jobs)
+	db.Close()
+	if err != nil {
+		return errors.Wrap(err, "withVariants")
+	}
+	err = rmContainers(context.Background(), jobs)
+	if err != nil {
+		return errors.Wrap(err, "rmContainers")
+	}
 	for _, version := range jobs {
 		execCmd := exec.Command("rm", "-rf", fmt.Sprintf("%s/%s/", bencher.HostVersionsPath, version))
 		err := execCmd.Run()
@@ -90,7 +150,15 @@ func (cmd *rmCmd) rmJobs(force bool, jobs ...string) error {
 			return errors.Wrap(err, "rm -rf")
 		}
 	}
-	db, err := initDB()
+	err = rmManifests(jobs...)
+	if err != nil {
+		return errors.Wrap(err, "rmManifests")
+	}
+	err = gcBlobs()
+	if err != nil {
+		return errors.Wrap(err, "gcBlobs")
+	}
+	db, err = initDB()
 	if err != nil {
 		return errors.Wrap(err, "initDB")
 	}
@@ -114,6 +182,35 @@ func (cmd *rmCmd) rmJobs(force bool, jobs ...string) error {
 	return nil
 }
 
+// withVariants adds the variants of the matrices among the given versions, either completed or pending
+func withVariants(db *bbolt.DB, versions []string) ([]string, error) {
+	all := versions
+	err := db.View(func(tx *bbolt.Tx) error {
+		for _, key := range [][]byte{bencher.KeyJob, bencher.KeyPending} {
+			b := tx.Bucket(key)
+			if b == nil {
+				continue
+			}
+			err := b.ForEach(func(version, jobBytes []byte) error {
+				j := &bencher.Job{}
+				err := json.Unmarshal(jobBytes, j)
+				if err != nil {
+					return err
+				}
+				if j.Base != "" && isInStrSl(j.Base, versions) && !isInStrSl(j.Version, all) {
+					all = append(all, j.Version)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return all, err
+}
+
 func stopRunningJob(ctx context.Context, cond func(version string) bool) error {
 	docker, err := client.NewClientWithOpts(client.FromEnv)
 	if err != nil {
@@ -131,7 +228,32 @@ func stopRunningJob(ctx context.Context, cond func(version string) bool) error {
 	if !cond(version) {
 		return nil
 	}
-	return docker.ContainerRemove(ctx, version, types.ContainerRemoveOptions{Force: true})
+	err = docker.ContainerRemove(ctx, version, types.ContainerRemoveOptions{Force: true})
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(bencher.RemoveSidecars(ctx, docker, version), "RemoveSidecars")
+}
+
+// rmContainers removes the created runners of the given versions along with their sidecars and network, so their
+// names can be taken again. The running version is left to stopRunningJob
+func rmContainers(ctx context.Context, versions []string) error {
+	docker, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return errors.Wrap(err, "docker.NewClientWithOpts")
+	}
+	defer docker.Close()
+	running, _ := getRunningVersion()
+	for _, version := range versions {
+		if version == running {
+			continue
+		}
+		err = removeContainers(ctx, docker, version)
+		if err != nil {
+			return errors.Wrapf(err, "removeContainers[%s]", version)
+		}
+	}
+	return nil
 }
 
 func getRunningVersion() (string, error) {
@@ -145,6 +267,14 @@ func getRunningVersion() (string, error) {
 func rmFromDB(db *bbolt.DB, versions ...string) (rest []string, err error) {
 	var delJobs []string
 	err = db.Update(func(tx *bbolt.Tx) error {
+		if bPending := tx.Bucket(bencher.KeyPending); bPending != nil {
+			for _, version := range versions {
+				err := bPending.Delete([]byte(version))
+				if err != nil {
+					return err
+				}
+			}
+		}
 		bJobs := tx.Bucket(bencher.KeyJob)
 		if bJobs == nil {
 			return nil
@@ -166,6 +296,10 @@ func rmFromDB(db *bbolt.DB, versions ...string) (rest []string, err error) {
 
 func rmAllFromDB(db *bbolt.DB) error {
 	return db.Update(func(tx *bbolt.Tx) error {
+		err := tx.DeleteBucket(bencher.KeyPending)
+		if err != nil && !errors.Is(err, bbolt.ErrBucketNotFound) {
+			return err
+		}
 		return tx.DeleteBucket(bencher.KeyJob)
 	})
 }
@@ -178,21 +312,14 @@ func rmAllFromSched(db *bbolt.DB) error {
 
 func rmFromSched(db *bbolt.DB, versions ...string) (rest []string, err error) {
 	err = db.Update(func(tx *bbolt.Tx) error {
-		bSched, err := tx.CreateBucketIfNotExists(bencher.KeySched)
-		if err != nil {
-			return err
-		}
-		sched := string(bSched.Get(bencher.KeySched))
-		var newSched string
-		var newSchedSl []string
-		for _, pendingVer := range strings.Split(sched, ",") {
+		var queue []string
+		for _, pendingVer := range bencher.Queue(tx) {
 			if !isInStrSl(pendingVer, versions) {
-				newSchedSl = append(newSchedSl, pendingVer)
-				newSched += fmt.Sprintf("%s,", pendingVer)
+				queue = append(queue, pendingVer)
 			}
 		}
-		rest = diffStrSl(versions, newSchedSl)
-		return bSched.Put(bencher.KeySched, []byte(newSched))
+		rest = diffStrSl(versions, queue)
+		return bencher.PutQueue(tx, queue)
 	})
 	return
 }
@@ -226,8 +353,10 @@ func (cmd *rmCmd) Synopsis() string {
 
 func (cmd *rmCmd) Help() string {
 	return `Usage: bencher rm [--all] [-f] <version1> [version2] [...]
+       bencher rm [-f] --tag <tag> [version1] [...]
 	
 Remove the specified version(s). If [--all] is given, delete all the versions 
+If [--tag] is given (can be given multiple times), remove the versions tagged with all of them, or just the given ones having them (see "bencher tag")
 If [-f] flag is given, include removing running versions 
 `
 }
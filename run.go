@@ -2,12 +2,17 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
@@ -16,6 +21,7 @@ import (
 	"github.com/docker/docker/api/types/strslice"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/namesgenerator"
+	"github.com/docker/go-units"
 	"github.com/mitchellh/cli"
 	"github.com/pkg/errors"
 	"github.com/schattian/bencher/internal/bencher"
@@ -24,28 +30,159 @@ import (
 
 type runCmd struct {
 	docker *client.Client
+
+	pull          string
+	cmd           []string // default go test command, given by the config
+	includes      []string // ignored paths to force on the snapshot
+	ignores       []string // extra ignore rules, relative to the module
+	snapshotLimit int64
+	matrix        []matrixAxis
+	serverCpuset  string
+}
+
+// runFlags are the flags of run, kept as given until the job is built. The ones not given are taken from the config
+type runFlags struct {
+	name, ref, image, profile, note     string
+	cpus, memory, pidsLimit, cpuset     string
+	env, envFiles                       stringsFlag
+	pull, snapshotLimit, timeout        string
+	network, priority                   string
+	quietFor, quietLoad, quietCPU       string
+	includes, setup, matrix, tags       stringsFlag
+	sidecars, sidecarEnv, sidecarHealth stringsFlag
+	dryRun                              bool
+
+	// only given by the config
+	cmd, baseEnv, ignores []string
+	maxTimeout            string
+}
+
+func newRunFlags() *runFlags {
+	return &runFlags{pull: pullMissing, network: bencher.NetworkNone}
+}
+
+func (f *runFlags) flagSet() *flag.FlagSet {
+	fs := newFlagSet("run")
+	fs.StringVar(&f.name, "name", f.name, "")
+	fs.StringVar(&f.ref, "ref", f.ref, "")
+	fs.StringVar(&f.profile, "p", f.profile, "")
+	fs.StringVar(&f.profile, "profile", f.profile, "")
+	fs.Var(&f.tags, "tag", "")
+	fs.StringVar(&f.note, "note", f.note, "")
+	fs.StringVar(&f.image, "image", f.image, "")
+	fs.StringVar(&f.cpus, "cpus", f.cpus, "")
+	fs.StringVar(&f.memory, "memory", f.memory, "")
+	fs.StringVar(&f.pidsLimit, "pids-limit", f.pidsLimit, "")
+	fs.StringVar(&f.cpuset, "cpuset", f.cpuset, "")
+	fs.Var(&f.env, "e", "")
+	fs.Var(&f.envFiles, "env-file", "")
+	fs.StringVar(&f.pull, "pull", f.pull, "")
+	fs.Var(&f.includes, "include", "")
+	fs.StringVar(&f.snapshotLimit, "snapshot-limit", f.snapshotLimit, "")
+	fs.StringVar(&f.timeout, "timeout", f.timeout, "")
+	fs.StringVar(&f.network, "network", f.network, "")
+	fs.StringVar(&f.priority, "priority", f.priority, "")
+	fs.StringVar(&f.quietFor, "quiet-for", f.quietFor, "")
+	fs.StringVar(&f.quietLoad, "quiet-load", f.quietLoad, "")
+	fs.StringVar(&f.quietCPU, "quiet-cpu", f.quietCPU, "")
+	fs.Var(&f.setup, "setup", "")
+	fs.Var(&f.sidecars, "sidecar", "")
+	fs.Var(&f.sidecarEnv, "sidecar-env", "")
+	fs.Var(&f.sidecarHealth, "sidecar-health", "")
+	fs.BoolVar(&f.dryRun, "dry-run", f.dryRun, "")
+	fs.Var(&f.matrix, "matrix", "")
+	return fs
+}
+
+// loadConfig fills the flags that weren't given from the config files of the module on root (if any) and its profile
+func (f *runFlags) loadConfig(root string, given map[string]bool) error {
+	c, err := loadConfig(root, f.profile)
+	if err != nil {
+		return err
+	}
+	c.apply(f, given)
+	return nil
 }
 
 func (cmd *runCmd) Run(args []string) int {
-	args, version := popNameFlag(args)
+	f := newRunFlags()
+	fs := f.flagSet()
+	if !parseFlags(fs, args) {
+		return cli.RunResultHelp
+	}
+	args = fs.Args() // the go test command, as given
+
+	root, _ := getModPath() // checked once the snapshot is taken, the user config applies anyway
+	err := f.loadConfig(root, givenFlags(fs))
+	if err != nil {
+		fmt.Printf("err loadConfig: %v", err)
+		return 1
+	}
+
+	version := f.name
+	var commit string
+	if f.ref != "" {
+		if root == "" {
+			fmt.Printf("err getModPath: not using modules")
+			return 1
+		}
+		var short string
+		commit, short, err = resolveCommit(root, f.ref)
+		if err != nil {
+			fmt.Printf("err resolveCommit: %v", err)
+			return 1
+		}
+		if version == "" {
+			version = unusedVersion(short) // the same commit can be run again
+			fmt.Printf("version name not given, using `%s` (commit of %s). To give a version name use the `-name` flag\n", version, f.ref)
+		}
+	}
+	var head, diff string
+	if f.ref == "" && root != "" {
+		var branch string
+		head, branch, diff, err = workingTree(root)
+		if err == nil && version == "" {
+			version, err = workingTreeVersion(root, head, branch, diff)
+			if err == nil {
+				version = unusedVersion(version) // the same code can be run again
+				fmt.Printf("version name not given, using `%s` (branch and commit of the working tree, and a hash of its uncommitted changes if any). To give a version name use the `-name` flag\n", version)
+			}
+		}
+		if err != nil { // not a git repository, or it has no commits yet
+			head, diff, version = "", "", f.name
+		}
+	}
 	if version == "" {
 		version = namesgenerator.GetRandomName(0)
 		fmt.Printf("version name not given, using `%s`. To give a version name use the `-name` flag\n", version)
 	}
 
-	args, image := popImageFlag(args)
-	if image == "" {
-		image = minDockerImage
-	}
-
 	ctx := context.Background()
+	j, err := cmd.configure(ctx, f)
+	if err != nil {
+		fmt.Printf("err %v", err)
+		return 1
+	}
+	j.Version, j.Commit, j.Head, j.Diff = version, commit, head, diff
 
-	err := errors.Wrap(cmd.prepareRuntime(ctx, version, args, image), "prepareRuntime")
+	if f.dryRun {
+		err = errors.Wrap(cmd.dryRun(ctx, j, args), "dryRun")
+		if err != nil {
+			fmt.Printf("err %v", err)
+			return 1
+		}
+		return 0
+	}
+	err = errors.Wrap(cmd.prepareRuntime(ctx, j, args), "prepareRuntime")
 	if err != nil {
 		fmt.Printf("err %v", err)
 		return 1
 	}
-	err = errors.Wrap(runServerCmd(ctx, cmd.docker, []string{"sched", version}, os.Getenv("BENCHER_DEBUG") != ""), "runServerCmd")
+	var versions []string
+	for _, v := range cmd.jobs(j) {
+		versions = append(versions, v.Version)
+	}
+	err = errors.Wrap(runServerCmd(ctx, cmd.docker, append([]string{"sched"}, versions...), cmd.serverCpuset, cmd.pull, os.Getenv("BENCHER_DEBUG") != ""), "runServerCmd")
 	if err != nil {
 		fmt.Printf("err %v", err)
 		return 1
@@ -53,57 +190,304 @@ func (cmd *runCmd) Run(args []string) int {
 	return 0
 }
 
-func popFlagBoolean(args []string, flagName string) ([]string, bool) {
-	singleFlagName := fmt.Sprintf("-%s", flagName)
-	doubleFlagName := fmt.Sprintf("-%s", singleFlagName)
-	for i, arg := range args {
-		if arg == singleFlagName || arg == doubleFlagName {
-			if len(args) <= i+1 {
-				return args[:i], true
-			}
-			return append(args[:i], args[i+1:]...), true
+// configure validates the flags, setting the options of the snapshot and the scheduler. It returns the job they
+// describe, to be given a version
+func (cmd *runCmd) configure(ctx context.Context, f *runFlags) (*bencher.Job, error) {
+	limits, err := parseLimits(f.cpus, f.memory, f.pidsLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := parseEnv(f.envFiles, f.env)
+	if err != nil {
+		return nil, err
+	}
+
+	limits.Cpuset, cmd.serverCpuset, err = pinCpus(ctx, cmd.docker, f.cpuset)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &bencher.Job{Limits: limits, Env: append(f.baseEnv[:len(f.baseEnv):len(f.baseEnv)], env...), Image: f.image, Network: f.network, Profile: f.profile}
+	cmd.pull = f.pull
+	err = checkPull(cmd.pull)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd.cmd, cmd.includes, cmd.ignores = f.cmd, f.includes, f.ignores
+	cmd.snapshotLimit = defaultSnapshotLimit
+	if f.snapshotLimit != "" {
+		cmd.snapshotLimit, err = units.RAMInBytes(f.snapshotLimit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --snapshot-limit value %q", f.snapshotLimit)
 		}
 	}
 
-	return args, false
+	maxTimeout := bencher.MaxTimeout
+	if f.maxTimeout != "" {
+		maxTimeout, err = time.ParseDuration(f.maxTimeout)
+		if err != nil || maxTimeout <= 0 || maxTimeout > bencher.MaxTimeout {
+			return nil, fmt.Errorf("invalid max-timeout value %q, must be a duration up to %s", f.maxTimeout, bencher.MaxTimeout)
+		}
+		j.Timeout = maxTimeout
+	}
+	if f.timeout != "" {
+		j.Timeout, err = time.ParseDuration(f.timeout)
+		if err != nil || j.Timeout <= 0 || j.Timeout > maxTimeout {
+			return nil, fmt.Errorf("invalid --timeout value %q, must be a duration up to %s", f.timeout, maxTimeout)
+		}
+	}
+
+	j.Priority = f.priority
+	if !bencher.IsPriority(j.Priority) {
+		return nil, fmt.Errorf("invalid --priority value %q, must be one of %s, %s or %s", j.Priority, bencher.PriorityHigh, bencher.PriorityNormal, bencher.PriorityLow)
+	}
+
+	j.Quiet, err = parseQuiet(f.quietFor, f.quietLoad, f.quietCPU)
+	if err != nil {
+		return nil, err
+	}
+
+	j.Tags, err = parseTags(f.tags)
+	if err != nil {
+		return nil, err
+	}
+	j.Note = f.note
+
+	j.Setup = f.setup
+	j.Sidecars, err = parseSidecars(f.sidecars, f.sidecarEnv, f.sidecarHealth)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd.matrix, err = parseMatrix(f.matrix)
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
 }
 
-func popFlagWithVal(args []string, flagName string) ([]string, string) {
-	pivot := -2
-	singleFlagName := fmt.Sprintf("-%s", flagName)
-	doubleFlagName := fmt.Sprintf("-%s", singleFlagName)
-	for i, arg := range args {
-		if arg == singleFlagName || arg == doubleFlagName {
-			pivot = i
+func checkPull(pull string) error {
+	if !isInStrSl(pull, []string{pullAlways, pullMissing, pullNever}) {
+		return fmt.Errorf("invalid --pull value %q, must be one of %s, %s or %s", pull, pullAlways, pullMissing, pullNever)
+	}
+	return nil
+}
+
+// configuredRun returns the run of the versions created by other commands (e.g: bisect), configured by the config
+// files and the given profile, along with the job each version is created from. Their matrix is ignored, as those
+// commands compare the versions themselves rather than their variants
+func configuredRun(ctx context.Context, docker *client.Client, profile string) (*runCmd, *bencher.Job, error) {
+	root, err := getModPath()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "getModPath")
+	}
+	f := newRunFlags()
+	f.profile = profile
+	err = f.loadConfig(root, nil)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "loadConfig")
+	}
+	if len(f.matrix) > 0 {
+		fmt.Printf("warning: the matrix of the config files is ignored, each commit is run once\n")
+		f.matrix = nil
+	}
+	cmd := &runCmd{docker: docker}
+	j, err := cmd.configure(ctx, f)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "configure")
+	}
+	return cmd, j, nil
+}
+
+// unusedVersion returns the given version, suffixed by a number if it was already taken (e.g: main-1a2b3c4-2)
+func unusedVersion(version string) string {
+	name := version
+	for i := 2; ; i++ {
+		if _, err := os.Stat(filepath.Join(bencher.HostVersionsPath, name)); os.IsNotExist(err) {
+			return name
 		}
-		if i == pivot+1 {
-			return append(args[:i-1], args[i+1:]...), arg
+		name = fmt.Sprintf("%s-%d", version, i)
+	}
+}
+
+// commitVersion names the version of a commit after it and a short hash of the spec it's run with, so runs of other
+// commands, env or image are never taken for it (e.g: bisect-1a2b3c4d5e6f-5f3e2a1b)
+func commitVersion(prefix, sha string, j *bencher.Job) string {
+	spec := strings.Join([]string{strings.Join(j.Cmd, "\x00"), strings.Join(j.Env, "\x00"), j.Image}, "\x01")
+	sum := sha256.Sum256([]byte(spec))
+	return fmt.Sprintf("%s-%s-%x", prefix, sha[:12], sum[:4])
+}
+
+// prepareCommits creates a version for each of the given commits from the given job, named after them. The versions
+// that already exist with the same spec are reused if pending or done, and recreated otherwise (e.g: errored), so only
+// the created ones are returned apart
+func (cmd *runCmd) prepareCommits(ctx context.Context, tmpl *bencher.Job, prefix string, shas []string, forward []string) (versions, created []string, err error) {
+	spec := *tmpl
+	spec.Cmd = cmd.commandOrDefault(forward)
+	for _, sha := range shas {
+		version := commitVersion(prefix, sha, &spec)
+		versions = append(versions, version)
+		db, err := initDB()
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "initDB")
 		}
-		if strings.HasPrefix(arg, fmt.Sprintf("%s=", doubleFlagName)) {
-			val := arg[len(flagName)+3:] // --=
-			if len(args) <= i+1 {
-				return args[:i], val
-			}
-			return append(args[:i], args[i+1:]...), val
+		jobs, err := getJobs(db, version)
+		pending := isPending(db, version)
+		db.Close()
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "getJobs")
 		}
-		if strings.HasPrefix(arg, fmt.Sprintf("%s=", singleFlagName)) {
-			val := arg[len(flagName)+2:] // -=
-			if len(args) <= i+1 {
-				return args[:i], val
+		if pending || (len(jobs) > 0 && jobs[0].Status() == "done") {
+			continue
+		}
+		if len(jobs) > 0 {
+			fmt.Printf("version %s is %s, recreating it\n", version, jobs[0].Status())
+			err = (&rmCmd{}).rmJobs(false, version)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "rmJobs[%s]", version)
 			}
-			return append(args[:i], args[i+1:]...), val
 		}
+		j := *tmpl
+		j.Version, j.Commit = version, sha
+		j.Sidecars = append([]bencher.Sidecar{}, tmpl.Sidecars...) // their images are resolved on each one
+		err = cmd.prepareRuntime(ctx, &j, forward)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "prepareRuntime[%s]", version)
+		}
+		created = append(created, version)
+	}
+	return versions, created, nil
+}
+
+// parseQuiet parses the quiet gate, enabled by --quiet-for
+func parseQuiet(quietFor, load, cpu string) (bencher.QuietGate, error) {
+	gate := bencher.QuietGate{Load: bencher.DefaultQuietLoad, CPU: bencher.DefaultQuietCPU}
+	var err error
+	if quietFor != "" {
+		gate.For, err = time.ParseDuration(quietFor)
+		if err != nil || gate.For < 0 {
+			return gate, fmt.Errorf("invalid --quiet-for value %q", quietFor)
+		}
+	}
+	if load != "" {
+		gate.Load, err = strconv.ParseFloat(load, 64)
+		if err != nil || gate.Load < 0 {
+			return gate, fmt.Errorf("invalid --quiet-load value %q", load)
+		}
+	}
+	if cpu != "" {
+		gate.CPU, err = strconv.ParseFloat(strings.TrimSuffix(cpu, "%"), 64)
+		if err != nil || gate.CPU < 0 {
+			return gate, fmt.Errorf("invalid --quiet-cpu value %q", cpu)
+		}
+	}
+	return gate, nil
+}
+
+// parseEnv reads the env files, followed by the given variables
+func parseEnv(envFiles, vars []string) ([]string, error) {
+	var env []string
+	for _, filename := range envFiles {
+		fileVars, err := readEnvFile(filename)
+		if err != nil {
+			return nil, errors.Wrap(err, "readEnvFile")
+		}
+		env = append(env, fileVars...)
+	}
+	for _, v := range vars {
+		env = append(env, expandEnv(v))
+	}
+	return env, nil
+}
+
+// readEnvFile reads KEY=VAL lines, skipping blank lines and # comments
+func readEnvFile(filename string) ([]string, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var env []string
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		env = append(env, expandEnv(line))
 	}
+	return env, nil
+}
 
-	return args, ""
+// expandEnv takes the value from the local environment when only the key is given (e.g: -e GOGC)
+func expandEnv(v string) string {
+	if strings.Contains(v, "=") {
+		return v
+	}
+	return fmt.Sprintf("%s=%s", v, os.Getenv(v))
 }
 
-func popNameFlag(args []string) ([]string, string) {
-	return popFlagWithVal(args, "name")
+func parseLimits(cpus, memory, pids string) (bencher.Limits, error) {
+	var limits bencher.Limits
+	if cpus != "" {
+		n, err := strconv.ParseFloat(cpus, 64)
+		if err != nil || n <= 0 {
+			return limits, fmt.Errorf("invalid --cpus value %q", cpus)
+		}
+		limits.CPUs = n
+	}
+	if memory != "" {
+		n, err := units.RAMInBytes(memory)
+		if err != nil || n <= 0 {
+			return limits, fmt.Errorf("invalid --memory value %q", memory)
+		}
+		limits.Memory = n
+	}
+	if pids != "" {
+		n, err := strconv.ParseInt(pids, 10, 64)
+		if err != nil || n <= 0 {
+			return limits, fmt.Errorf("invalid --pids-limit value %q", pids)
+		}
+		limits.PidsLimit = n
+	}
+	return limits, nil
 }
 
-func popImageFlag(args []string) ([]string, string) {
-	return popFlagWithVal(args, "image")
+const autoCpuset = "auto"
+
+// pinCpus resolves the cores the runner is pinned to (either given or "auto"), and picks a different one for the scheduler
+func pinCpus(ctx context.Context, docker *client.Client, cpuset string) (runner, server string, err error) {
+	if cpuset == "" {
+		return "", "", nil
+	}
+	info, err := docker.Info(ctx)
+	if err != nil {
+		return "", "", errors.Wrap(err, "docker.Info")
+	}
+	if info.NCPU < 2 {
+		return "", "", fmt.Errorf("pinning cpus needs at least 2 cpus on the docker host, got %d", info.NCPU)
+	}
+	if cpuset == autoCpuset {
+		runner = fmt.Sprintf("1-%d", info.NCPU-1)
+		if info.NCPU == 2 {
+			runner = "1"
+		}
+		return runner, "0", nil
+	}
+	cores, err := bencher.ParseCpuset(cpuset)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid --cpuset value %q", cpuset)
+	}
+	for core := range cores {
+		if core >= info.NCPU {
+			return "", "", fmt.Errorf("invalid --cpuset value %q: the docker host has %d cpus", cpuset, info.NCPU)
+		}
+	}
+	server = bencher.Limits{Cpuset: cpuset}.SchedulerCpuset(info.NCPU)
+	if server == "" {
+		fmt.Println("the cpuset takes all the cpus, the scheduler won't be pinned")
+	}
+	return cpuset, server, nil
 }
 
 func pruneContainers(ctx context.Context, docker *client.Client) error {
@@ -115,7 +499,7 @@ func pruneContainers(ctx context.Context, docker *client.Client) error {
 	return nil
 }
 
-func (cmd *runCmd) prepareRuntime(ctx context.Context, version string, forward []string, image string) error {
+func (cmd *runCmd) prepareRuntime(ctx context.Context, j *bencher.Job, forward []string) error {
 	if _, err := os.Stat(bencher.HostServerRootPath); os.IsNotExist(err) {
 		fmt.Println("preparing bencher runtime, this could take a while as it's your first time...")
 	}
@@ -132,57 +516,323 @@ func (cmd *runCmd) prepareRuntime(ctx context.Context, version string, forward [
 		return errors.Wrap(err, "getModPath")
 	}
 	// adding filepath.Base(root) could be useful as a prefix
-	versionPath := filepath.Join(bencher.HostVersionsPath, version)
+	versionPath := filepath.Join(bencher.HostVersionsPath, j.Version)
+	err = os.RemoveAll(versionPath) // left by a previous version of the same name, its files are only unlinked
+	if err != nil {
+		return errors.Wrap(err, "RemoveAll")
+	}
 	err = os.MkdirAll(versionPath, os.ModePerm)
 	if err != nil {
 		return err
 	}
 
-	execCmd := exec.Command("rsync", "-a", root+"/", versionPath, "--exclude", ".git")
-	err = execCmd.Run()
+	if j.Commit != "" {
+		j.CommitTime, j.CommitSubject, err = commitInfo(root, j.Commit)
+		if err != nil {
+			return errors.Wrap(err, "commitInfo")
+		}
+	}
+	snapshot, layout, cleanup, err := cmd.listSnapshot(j, root, true)
+	if err != nil {
+		return errors.Wrap(err, "listSnapshot")
+	}
+	defer cleanup()
+	m, added, err := snapshot.store()
+	if err != nil {
+		return errors.Wrap(err, "snapshot.store")
+	}
+	snapshot.report(cmd.snapshotLimit, added)
+	link := len(j.Setup) == 0 // the setup steps may write on the snapshot, so it can't share the files of the blobs
+	err = materialize(m, versionPath, link, false)
+	if err != nil {
+		return errors.Wrap(err, "materialize")
+	}
+
+	vendorDir := filepath.Join(versionPath, filepath.FromSlash(layout.rel(layout.vendorDir())))
+	vendorCmd := []string{"go", "mod", "vendor"}
+	if layout.work != "" {
+		vendorCmd = []string{"go", "work", "vendor"}
+	}
+	execCmd := exec.Command(vendorCmd[0], vendorCmd[1:]...)
+	execCmd.Dir = vendorDir
+	out, err := execCmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %v: %s", strings.Join(vendorCmd, " "), err, strings.TrimSpace(string(out)))
+	}
+	vendored, _, err := storeVendor(versionPath, filepath.Join(vendorDir, "vendor"), link)
+	if err != nil {
+		return errors.Wrap(err, "storeVendor")
+	}
+	m.Files = append(m.Files, vendored...)
+	err = writeManifest(j.Version, m)
 	if err != nil {
-		return errors.Wrap(err, "rsync")
+		return errors.Wrap(err, "writeManifest")
 	}
 
-	execCmd = exec.Command("go", "mod", "vendor")
-	execCmd.Dir = versionPath
-	err = execCmd.Run()
+	if j.Image == "" {
+		j.Image, err = moduleImage(filepath.Join(versionPath, filepath.FromSlash(layout.rel(root))), os.ReadFile)
+		if err != nil {
+			return errors.Wrap(err, "moduleImage")
+		}
+	}
+	err = ensureImage(ctx, cmd.docker, j.Image, cmd.pull)
 	if err != nil {
-		return errors.Wrap(err, "go mod vendor")
+		return errors.Wrap(err, "ensureImage")
 	}
+	j.Image, err = resolveDigest(ctx, cmd.docker, j.Image)
+	if err != nil {
+		return errors.Wrap(err, "resolveDigest")
+	}
+	for i, sc := range j.Sidecars {
+		err = ensureImage(ctx, cmd.docker, sc.Image, cmd.pull)
+		if err != nil {
+			return errors.Wrapf(err, "ensureImage[%s]", sc.Name)
+		}
+		j.Sidecars[i].Image, err = resolveDigest(ctx, cmd.docker, sc.Image)
+		if err != nil {
+			return errors.Wrapf(err, "resolveDigest[%s]", sc.Name)
+		}
+	}
+	j.Cmd, j.Dir = cmd.commandOrDefault(forward), layout.workingDir(wd)
+	for _, v := range cmd.jobs(j) {
+		err = createJob(ctx, cmd.docker, v, versionPath)
+		if err != nil {
+			return errors.Wrapf(err, "createJob[%s]", v.Version)
+		}
+	}
+	return nil
+}
 
-	// todo: add go version by module on this path
-	r, err := cmd.docker.ImagePull(ctx, image, types.ImagePullOptions{})
+// listSnapshot lists the files of the module on root along with the local modules of its layout, taken from the
+// commit of the job if any. The commit is exported so its files can be stored, unless !export where they're only listed
+// from its tree. The returned cleanup removes the export
+func (cmd *runCmd) listSnapshot(j *bencher.Job, root string, export bool) (*snapshotter, *moduleLayout, func(), error) {
+	cleanup := func() {}
+	readFile := os.ReadFile
+	src := func(p string) string { return p }
+	var toplevel string
+	if j.Commit != "" {
+		var err error
+		toplevel, err = git(root, "rev-parse", "--show-toplevel")
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		readFile = func(p string) ([]byte, error) { return showFile(toplevel, j.Commit, p) }
+	}
+	layout, err := findLayout(root, readFile)
 	if err != nil {
-		return err
+		return nil, nil, nil, errors.Wrap(err, "findLayout")
+	}
+	var paths []string // of the commit, along with the ignore files of the dirs above them
+	if j.Commit != "" {
+		paths = append(append(paths, layout.dirs...), layout.files...)
+		paths = append(paths, parentIgnoreFiles(toplevel, paths)...)
+	}
+	var fsys snapshotFS = osFS{}
+	switch {
+	case j.Commit != "" && export:
+		dst, err := exportCommit(toplevel, j.Commit, paths)
+		if err != nil {
+			return nil, nil, nil, errors.Wrap(err, "exportCommit")
+		}
+		cleanup = func() { os.RemoveAll(dst) }
+		src = func(p string) string {
+			rel, _ := filepath.Rel(toplevel, p)
+			return filepath.Join(dst, rel)
+		}
+	case j.Commit != "":
+		fsys, err = listCommit(toplevel, j.Commit, paths)
+		if err != nil {
+			return nil, nil, nil, errors.Wrap(err, "listCommit")
+		}
+	}
+
+	var includes []string
+	for _, include := range cmd.includes { // given relative to the module
+		includes = append(includes, path.Join(layout.rel(root), include))
+	}
+	snapshot := newSnapshotter(src(layout.base), includes)
+	snapshot.fsys = fsys
+	if j.Commit != "" {
+		snapshot.toplevel = filepath.ToSlash(src(toplevel))
+	}
+	snapshot.ignore(src(root), cmd.ignores)
+	var dirs []string
+	for _, dir := range layout.dirs {
+		dirs = append(dirs, src(dir))
+	}
+	err = snapshot.list(dirs...)
+	if err == nil {
+		for _, f := range layout.files {
+			err = snapshot.listFile(src(f))
+			if err != nil {
+				break
+			}
+		}
 	}
-	defer r.Close()
-	_, err = io.Copy(io.Discard, r)
 	if err != nil {
-		return errors.Wrap(err, "io.Copy")
+		cleanup()
+		return nil, nil, nil, errors.Wrap(err, "snapshot.list")
+	}
+	return snapshot, layout, cleanup, nil
+}
+
+// parentIgnoreFiles returns the ignore files that may be on the dirs from toplevel down to the ones of the given paths
+func parentIgnoreFiles(toplevel string, paths []string) []string {
+	var files []string
+	seen := map[string]bool{}
+	for _, p := range paths {
+		for dir := filepath.Dir(p); !seen[dir] && strings.HasPrefix(dir+string(os.PathSeparator), toplevel+string(os.PathSeparator)); dir = filepath.Dir(dir) {
+			seen[dir] = true
+			for _, filename := range ignoreFilenames {
+				files = append(files, filepath.Join(dir, filename))
+			}
+		}
 	}
+	return files
+}
+
+func (cmd *runCmd) commandOrDefault(forward []string) []string {
 	if len(forward) == 0 || (len(forward) == 1 && forward[0] == ".") { // . is alias of nothing since we run it in wd
 		// fmt.Printf("command not given, using the default one (`go test -bench=. -benchmem`). To give a command just use args\n")
-		forward = defaultCmd
+		if len(cmd.cmd) > 0 {
+			return cmd.cmd
+		}
+		return defaultCmd
 	}
+	return forward
+}
 
-	err = createContainer(ctx, cmd.docker, version, versionPath, forward, wd[len(root):], image)
+// jobs returns the jobs to run for the given one: itself, or a variant of it for each combination of the matrix
+func (cmd *runCmd) jobs(j *bencher.Job) []*bencher.Job {
+	if len(cmd.matrix) == 0 {
+		return []*bencher.Job{j}
+	}
+	var variants []*bencher.Job
+	for _, variant := range expandMatrix(cmd.matrix) {
+		v := *j
+		v.Version, v.Base, v.Variant = variantVersion(j.Version, variant), j.Version, variant
+		v.Env = append(append([]string{}, j.Env...), variant...)
+		variants = append(variants, &v)
+	}
+	return variants
+}
+
+// createJob creates the runner of the job and saves it as pending, so the server can pick it up
+func createJob(ctx context.Context, docker *client.Client, j *bencher.Job, versionPath string) error {
+	err := createContainer(ctx, docker, j, versionPath)
 	if err != nil {
 		return errors.Wrap(err, "createContainer")
 	}
-	db, err := initDB() // just used to ensure db fs is reachable by the client
-	defer db.Close()
+	db, err := initDB()
 	if err != nil {
 		return errors.Wrap(err, "initDB")
 	}
+	defer db.Close()
+	err = j.SavePending(db)
+	if err != nil {
+		return errors.Wrap(err, "SavePending")
+	}
 	return nil
 }
 
+// moduleImage picks the official golang image matching the toolchain (or go directive) of the module on the given path
+func moduleImage(modPath string, readFile func(string) ([]byte, error)) (string, error) {
+	b, err := readFile(filepath.Join(modPath, "go.mod"))
+	if err != nil {
+		return "", err
+	}
+	var goVersion, toolchain string
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "go":
+			goVersion = fields[1]
+		case "toolchain":
+			toolchain = strings.TrimPrefix(fields[1], "go")
+		}
+	}
+	if toolchain != "" && toolchain != "default" {
+		goVersion = toolchain
+	}
+	if goVersion == "" {
+		return minDockerImage, nil
+	}
+	return fmt.Sprintf("golang:%s-alpine", goVersion), nil
+}
+
+// resolveDigest targets the given image by its digest, so reruns use exactly the same image
+func resolveDigest(ctx context.Context, docker *client.Client, image string) (string, error) {
+	if strings.Contains(image, "@") {
+		return image, nil
+	}
+	info, _, err := docker.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		return "", err
+	}
+	repo := image
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		repo = image[:i]
+	}
+	for _, digest := range info.RepoDigests {
+		if strings.HasPrefix(digest, repo+"@") {
+			return digest, nil
+		}
+	}
+	if len(info.RepoDigests) > 0 {
+		return info.RepoDigests[0], nil
+	}
+	return image, nil // built locally, it has no digest
+}
+
 func initDB() (*bbolt.DB, error) {
 	return bbolt.Open(bencher.HostDBFilename, 0600, bbolt.DefaultOptions)
 }
 
-func runServerCmd(ctx context.Context, docker *client.Client, cmd []string, debug bool) error {
+const (
+	pullAlways  = "always"
+	pullMissing = "missing"
+	pullNever   = "never"
+)
+
+// ensureImage makes the image available on the docker host following the given pull policy
+func ensureImage(ctx context.Context, docker *client.Client, image, pull string) error {
+	if pull != pullAlways {
+		_, _, err := docker.ImageInspectWithRaw(ctx, image)
+		if err == nil {
+			return nil
+		}
+		if !client.IsErrNotFound(err) {
+			return errors.Wrap(err, "ImageInspect")
+		}
+		if pull == pullNever {
+			return fmt.Errorf("image %s is missing on the docker host and --pull=%s was given. Load it with `docker load -i <archive>` (e.g: created by `docker save %s` on a connected machine)", image, pullNever, image)
+		}
+	}
+	r, err := docker.ImagePull(ctx, image, types.ImagePullOptions{})
+	if err != nil {
+		return fmt.Errorf("couldn't pull image %s: %v. If the docker host is offline, load it with `docker load -i <archive>` and use --pull=%s", image, err, pullNever)
+	}
+	defer r.Close()
+	_, err = io.Copy(io.Discard, r)
+	if err != nil {
+		return errors.Wrap(err, "io.Copy")
+	}
+	return nil
+}
+
+func runServerCmd(ctx context.Context, docker *client.Client, cmd []string, cpuset, pull string, debug bool) error {
+	err := ensureImage(ctx, docker, bencher.ServerImage, pull)
+	if err != nil {
+		return errors.Wrap(err, "ensureImage")
+	}
+	return startServer(ctx, docker, cmd, cpuset, debug)
+}
+
+func startServer(ctx context.Context, docker *client.Client, cmd []string, cpuset string, debug bool) error {
 	containerName := fmt.Sprintf("%s_%s", bencher.ContainersLabel, namesgenerator.GetRandomName(0))
 	r, err := docker.ContainerCreate(
 		ctx,
@@ -212,25 +862,14 @@ func runServerCmd(ctx context.Context, docker *client.Client, cmd []string, debu
 					Target: bencher.ServerRootPath,
 				},
 			},
+			Resources: container.Resources{CpusetCpus: cpuset},
 		},
 		nil,
 		nil,
 		containerName,
 	)
-	if isNoSuchImage(err) { // todo: easier invalidation
-		r, err := docker.ImagePull(ctx, bencher.ServerImage, types.ImagePullOptions{})
-		if err != nil {
-			return err
-		}
-		defer r.Close()
-		_, err = io.Copy(io.Discard, r)
-		if err != nil {
-			return errors.Wrap(err, "io.Copy")
-		}
-		return runServerCmd(ctx, docker, cmd, debug)
-	}
 	if isContainerExists(err) {
-		return runServerCmd(ctx, docker, cmd, debug)
+		return startServer(ctx, docker, cmd, cpuset, debug)
 	}
 	if err != nil {
 		return errors.Wrap(err, "create")
@@ -250,13 +889,6 @@ func runServerCmd(ctx context.Context, docker *client.Client, cmd []string, debu
 	return nil
 }
 
-func isNoSuchImage(err error) bool {
-	if err == nil {
-		return false
-	}
-	return strings.Contains(err.Error(), "No such image")
-}
-
 func isContainerExists(err error) bool {
 	if err == nil {
 		return false
@@ -287,40 +919,152 @@ func getModPath() (string, error) {
 	}
 }
 
-func createContainer(ctx context.Context, docker *client.Client, version, versionPath string, cmd []string, wd string, image string) error {
-	_, err := docker.ContainerCreate(
-		ctx,
-		&container.Config{
-			Image:      image,
-			Env:        []string{"CGO_ENABLED=0"}, // TODO
-			Labels:     map[string]string{bencher.ContainersLabel: "runner"},
-			WorkingDir: bencher.RunnerRootPath + wd,
-			Entrypoint: strslice.StrSlice{""},
-			Volumes: map[string]struct{}{
-				versionPath: {},
-			},
-			Cmd: cmd,
-		},
-		&container.HostConfig{
-			Mounts: []mount.Mount{
-				{
-					Type:   mount.TypeBind,
-					Source: versionPath,
-					Target: bencher.RunnerRootPath,
-				},
-			},
-		},
-		nil,
-		nil,
-		version,
-	)
-
+func createContainer(ctx context.Context, docker *client.Client, j *bencher.Job, versionPath string) error {
+	caches, err := cacheMounts(ctx, docker, j.Image)
+	if err != nil {
+		return errors.Wrap(err, "cacheMounts")
+	}
+	if len(j.Sidecars) > 0 {
+		err = createSidecars(ctx, docker, j)
+		if err != nil {
+			return errors.Wrap(err, "createSidecars")
+		}
+	}
+	config, hostConfig := runnerConfig(j, versionPath, caches)
+	_, err = docker.ContainerCreate(ctx, config, hostConfig, nil, nil, j.Version)
 	if err != nil {
+		bencher.RemoveSidecars(ctx, docker, j.Version)
 		return err
 	}
+	if len(j.Sidecars) > 0 && runnerNetwork(j) != bencher.NetworkNone {
+		err = docker.NetworkConnect(ctx, runnerNetwork(j), j.Version, nil)
+		if err != nil {
+			removeContainers(ctx, docker, j.Version)
+			return errors.Wrap(err, "NetworkConnect")
+		}
+	}
 	return nil
 }
 
+// removeContainers removes the created runner of the version, along with its sidecars and network
+func removeContainers(ctx context.Context, docker *client.Client, version string) error {
+	err := docker.ContainerRemove(ctx, version, types.ContainerRemoveOptions{Force: true})
+	if err != nil && !client.IsErrNotFound(err) {
+		return errors.Wrap(err, "ContainerRemove")
+	}
+	return errors.Wrap(bencher.RemoveSidecars(ctx, docker, version), "RemoveSidecars")
+}
+
+// runnerNetwork is the network the runner of the job is attached to, besides the one of its sidecars
+func runnerNetwork(j *bencher.Job) string {
+	if j.Network == "" {
+		return bencher.NetworkNone
+	}
+	return j.Network
+}
+
+// runnerConfig is the config of the runner container of the job, mounting the given caches
+func runnerConfig(j *bencher.Job, versionPath string, caches []mount.Mount) (*container.Config, *container.HostConfig) {
+	env := []string{"CGO_ENABLED=0", "GOCACHE=" + runnerGoCache, "GOMODCACHE=" + runnerGoModCache}
+	env = append(env, sidecarsEnv(j.Sidecars)...)
+	networkMode := container.NetworkMode(runnerNetwork(j))
+	if len(j.Sidecars) > 0 {
+		networkMode = container.NetworkMode(bencher.NetworkName(j.Version)) // connected to the runner one once created
+	}
+	if runnerNetwork(j) == bencher.NetworkNone {
+		env = append(env, "GOPROXY=off", "GOTOOLCHAIN=local") // fail fast when something isn't vendored
+	}
+	config := &container.Config{
+		Image:      j.Image,
+		Env:        append(env, j.Env...),
+		Labels:     map[string]string{bencher.ContainersLabel: "runner"},
+		WorkingDir: bencher.RunnerRootPath + j.Dir,
+		Entrypoint: strslice.StrSlice{""},
+		Volumes: map[string]struct{}{
+			versionPath: {},
+		},
+		Cmd: runnerCmd(j.Setup, j.Cmd),
+	}
+	hostConfig := &container.HostConfig{
+		Mounts: append([]mount.Mount{
+			{
+				Type:     mount.TypeBind,
+				Source:   versionPath,
+				Target:   bencher.RunnerRootPath,
+				ReadOnly: len(j.Setup) == 0, // its files are hardlinks to the blobs
+			},
+		}, caches...),
+		Resources:   j.Limits.Resources(),
+		NetworkMode: networkMode,
+	}
+	return config, hostConfig
+}
+
+// testRunFlags are the go test flags that only affect the execution of the tests, the ones taking a value when not given with =
+var testRunFlags = map[string]bool{
+	"bench": true, "benchtime": true, "count": true, "cpu": true, "run": true, "timeout": true,
+	"benchmem": false, "v": false, "json": false, "short": false, "failfast": false, "exec": true,
+}
+
+// runnerCmd runs the setup steps, whose output is written apart, and compiles the tests before running the given
+// go test command, so the build of a package never overlaps with the benchmarks of another one and its time is measured apart
+func runnerCmd(setup, cmd []string) []string {
+	build := precompileCmd(cmd)
+	if len(setup) == 0 && build == nil {
+		return cmd
+	}
+	script := "exec " + shellJoin(cmd)
+	if build != nil {
+		script = fmt.Sprintf("%s >/dev/null && %s", shellJoin(build), script)
+	}
+	if len(setup) > 0 {
+		steps := make([]string, len(setup))
+		for i, step := range setup {
+			steps[i] = shellJoin([]string{"sh", "-c", step})
+		}
+		// the log is touched once done, so its modification time tells when the setup ended
+		script = fmt.Sprintf("{ %s; } >%s 2>&1 || exit %d\ntouch %[2]s\n%[4]s",
+			strings.Join(steps, " && "), bencher.RunnerSetupLog, bencher.SetupFailedExitCode, script)
+	}
+	return []string{"sh", "-c", script}
+}
+
+// precompileCmd returns the go test command that only compiles the tests of the given one, or nil if it's not a go test command
+func precompileCmd(cmd []string) []string {
+	if len(cmd) < 2 || cmd[0] != "go" || cmd[1] != "test" {
+		return nil
+	}
+	build := []string{"go", "test", "-count=1", "-run=^$", "-bench=^$", "-exec=true"} // the binaries are built but never run
+	for i := 2; i < len(cmd); i++ {
+		arg := cmd[i]
+		if arg == "-args" || arg == "--args" { // the rest is given to the test binary
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			build = append(build, arg)
+			continue
+		}
+		name := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		takesVal, isRunFlag := testRunFlags[name]
+		if !isRunFlag {
+			build = append(build, arg)
+			continue
+		}
+		if takesVal && !strings.Contains(arg, "=") {
+			i++ // skip its value
+		}
+	}
+	return build
+}
+
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
 func prepareRun() (cli.Command, error) {
 	docker, err := client.NewClientWithOpts(client.FromEnv)
 	if err != nil {
@@ -335,11 +1079,58 @@ func (cmd *runCmd) Synopsis() string {
 }
 
 func (cmd *runCmd) Help() string {
-	return `Usage: bencher run [--name] [--image] [go test command]
+	return `Usage: bencher run [--name] [--ref] [-p] [--tag] [--note] [--image] [--cpus] [--memory] [--pids-limit] [--cpuset] [-e] [--env-file] [--pull] [--include] [--snapshot-limit] [--timeout] [--network] [--priority] [--quiet-for] [--setup] [--sidecar] [--matrix] [--dry-run] [go test command]
 
 Schedule a benchmark to be run, given by the [go test command], and being "go test-bench=. -benchmem" the default value
 You can pass whichever flag you want to the [go test command] (e.g: bencher run go test -bench=^Regex -benchtime=5m -benchmem -v -run=^$)
+The bencher flags must be given before the [go test command], which is forwarded as it is. Use -- to end them explicitly (e.g: bencher run --cpus 2 -- go test -timeout 1h)
 Consider that the command uses the current working directory and that can also be ran over subdirectories
 
-If [--image] given, you can run under the specified docker image (default: golang alpine, targeted by digest)`
+If [--ref] given (e.g: main, stash@{0}), the module is exported as of that git ref instead of the working tree, without checking it out.
+The version is named after the short hash of the commit by default (e.g: 1a2b3c4, or 1a2b3c4-2 if already taken). Otherwise, it's named after the branch and short hash of the commit the working tree is on,
+followed by a hash of its uncommitted changes if any (e.g: main-1a2b3c4-dirty-5f3e2a1b). The commit and the full diff of those changes are kept on the job (see "bencher get <version>")
+If [--tag] (e.g: --tag parser, can be given multiple times) or [--note] (e.g: --note "try sync.Pool") given, the version is described by them.
+They can be edited later with "bencher tag" and "bencher note", and the tags filter the versions of "bencher get", "bencher cmp" and "bencher rm"
+If [--image] given, you can run under the specified docker image (default: golang alpine matching the go.mod toolchain or go directive).
+The image is targeted by its digest, which is saved with the job so you can rerun it under the same compiler with [--image]
+The snapshot is mounted as read-only, its files are deduplicated across versions.
+If [--setup] given (e.g: --setup "go generate ./..." --setup "make testdata"), the steps are run in order on the runner before the command, on its working dir,
+and the snapshot is writable for them. Their output is kept apart from the benchmarks one, and the job is reported as "setup-failed" if any of them fails
+If [--sidecar] given (e.g: --sidecar postgres=postgres:16-alpine), the service is started on a private network of the job before the runner, and removed after it.
+It's configured by [--sidecar-env] (e.g: --sidecar-env postgres:POSTGRES_PASSWORD=bench) and [--sidecar-health], a command that must succeed before the runner starts (e.g: --sidecar-health "postgres:pg_isready").
+The runner is given its address as <NAME>_HOST and <NAME>_ADDR (e.g: POSTGRES_ADDR=postgres:5432, on the lowest port exposed by the image).
+The job is reported as "sidecar-failed" if any of them isn't ready after 2m
+The module is snapshotted honouring its .gitignore and .bencherignore files, along with the modules of its go.work and the ones replaced by local paths (e.g: replace example.com/x => ../x),
+keeping their relative layout. Ignored paths can be forced with [--include] (e.g: --include testdata/large),
+and a warning is shown when the snapshot exceeds [--snapshot-limit] (default: 1GiB)
+[--pull] sets when the runner and server images are pulled: always, missing (default) or never (for offline docker hosts)
+If [--cpus], [--memory] (e.g: 4g) or [--pids-limit] given, the runner container is constrained to those resources.
+A runner killed for exceeding its memory is reported as "oom-killed"
+If [--timeout] given (e.g: 30m), the runner is stopped once it runs for longer, keeping its partial output, and the job is reported as "timed-out".
+Every job is capped to 24h, so the next ones in the queue are never blocked forever
+The runner has no network by default, as the dependencies are vendored on the host. If [--network] given (e.g: bridge, host or the name of a docker network),
+it's attached to that network instead. Its sidecars are reachable anyway
+[--priority] orders the job in the queue: high, normal (default) or low. It's queued after the jobs of the same or higher priority (see "bencher queue")
+If [--quiet-for] given (e.g: 30s), the runner isn't started until the host stays quiet for that long: its 1m load average under [--quiet-load] (default: 1)
+and the cpu used by the containers not run by bencher under [--quiet-cpu] (default: 10%). It's started anyway after waiting for 30m.
+The load measured right before starting is kept on the job
+If [--cpuset] given (e.g: 2-5), the runner is pinned to those cpus and the scheduler to a different one. Use "auto" to reserve all the cpus but the first one for the runner
+If [-e] (e.g: -e GOGC=off -e GOFLAGS=-count=5) or [--env-file] given, the variables are set on the runner. Both can be given multiple times
+
+If [--matrix] is given (e.g: --matrix 'GOGC=50|100|off' --matrix 'GOEXPERIMENT=arenas|arenas,boringcrypto'), a job is scheduled for each combination of the variables,
+all of them sharing the same snapshot. The values are separated by | (quoted on the shell), so they can hold commas. Use "bencher cmp <version>" to compare them grouped by each variable
+
+The defaults of the flags can be given by a .bencher.toml (or .bencher.yaml) file at the module root, and by a ~/.bencher/config.toml (or config.yaml) one for every module.
+Their keys are named after the flags (e.g: image, cpus, memory, env, env-file, timeout, quiet-for, setup, include), along with:
+  cmd: the default [go test command], as a list
+  ignore: ignore rules added to the ones of the .gitignore and .bencherignore files, relative to the module
+  max-timeout: the longest [--timeout] allowed, also used as the timeout of the jobs not given one
+  sidecars: the sidecars by name, each one given its image, env and healthcheck (e.g: [sidecars.postgres] image = "postgres:16-alpine")
+  profiles: named sets of keys applied on top of the rest, selected by [-p] (e.g: [profiles.quick] cmd = ["go", "test", "-bench=.", "-benchtime=100ms"])
+The project file overrides the user one, and the flags given override both. The env of all of them is added in that order, the rest of the lists are replaced.
+The profile used is kept on the job
+
+If [--dry-run] given, the module root, snapshot files, image, env, limits, command and runner container config are printed, without creating anything
+
+The tests are compiled before running the benchmarks, sharing the build cache across runners of the same image (see "bencher cache"), and the compile time is reported apart`
 }
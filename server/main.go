@@ -9,13 +9,13 @@ api:
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"math/rand"
 	"os"
 	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
 	"github.com/mitchellh/cli"
 	"github.com/pkg/errors"
@@ -71,70 +71,98 @@ var schedKey = []byte("sched")
 
 type runCmd struct{}
 
-func run(ctx context.Context, j *bencher.Job) error {
-	pidUnlock, err := pidLock(j.Version)
-	if os.IsExist(err) {
-		log.Printf("scheduling %s", j.Version)
-		sched(j)
-		return nil
-		// todo corner race: lock in case pid is going to be released and runNext is going to run then
-	}
-	if err != nil {
-		return errors.Wrap(err, "pidLock")
+// runQueue runs the queued jobs in order until none is left. It returns right away if another server is running
+// them, as that one picks up the rest of the queue
+func runQueue(ctx context.Context) error {
+	for {
+		j, err := lookupNext()
+		if err != nil {
+			return errors.Wrap(err, "lookupNext")
+		}
+		if j == nil {
+			return nil
+		}
+		pidUnlock, err := pidLock(j.Version)
+		if os.IsExist(err) {
+			log.Printf("scheduled, the queue is run by another server")
+			return nil
+			// todo corner race: lock in case pid is going to be released and the other server found the queue empty
+		}
+		if err != nil {
+			return errors.Wrap(err, "pidLock")
+		}
+		found, err := unsched(j.Version)
+		if err != nil || !found { // run by the server that held the lock meanwhile
+			pidUnlock()
+			if err != nil {
+				return errors.Wrap(err, "unsched")
+			}
+			continue
+		}
+		err = run(ctx, j)
+		pidUnlock()
+		if err != nil {
+			return errors.Wrapf(err, "run[%s]", j.Version)
+		}
 	}
+}
 
+func run(ctx context.Context, j *bencher.Job) error {
 	docker, err := client.NewClientWithOpts(client.FromEnv)
 	if err != nil {
 		return err
 	}
 	defer docker.Close()
 
-	log.Printf("running %s", j.Version)
-	err = j.RunNow(ctx, initDB, docker)
-	pidUnlock()
+	err = waitQuiet(ctx, docker, j)
 	if err != nil {
-		return errors.Wrap(err, "runNow")
+		return errors.Wrap(err, "waitQuiet")
 	}
-	err = runNext(ctx, j)
+	err = pinScheduler(ctx, docker, j)
 	if err != nil {
-		return errors.Wrap(err, "runNext")
+		return errors.Wrap(err, "pinScheduler")
 	}
-	return nil
+	log.Printf("running %s", j.Version)
+	return errors.Wrap(j.RunNow(ctx, initDB, docker), "runNow")
 }
 
-func runNext(ctx context.Context, j *bencher.Job) error {
-	docker, err := client.NewClientWithOpts(client.FromEnv)
-	if err != nil {
-		return err
+// pinScheduler moves the server out of the cores of the job runner. It may have been started for another job, so
+// the cpuset it was created with can't be relied on
+func pinScheduler(ctx context.Context, docker *client.Client, j *bencher.Job) error {
+	if j.Limits.Cpuset == "" {
+		return nil
 	}
-	defer docker.Close()
-
-	nextJ, err := lookupNext()
+	info, err := docker.Info(ctx)
 	if err != nil {
-		return errors.Wrap(err, "lookupNext")
+		return errors.Wrap(err, "Info")
 	}
-	if nextJ == nil {
-		return nil
+	cpuset := j.Limits.SchedulerCpuset(info.NCPU)
+	if cpuset == "" {
+		return nil // the runner takes all the cores
 	}
-	err = unsched(nextJ.Version)
+	self, err := os.Hostname() // the id of the server container
 	if err != nil {
-		return errors.Wrap(err, "unsched")
+		return err
 	}
-	return errors.Wrap(run(ctx, nextJ), "run")
+	_, err = docker.ContainerUpdate(ctx, self, container.UpdateConfig{Resources: container.Resources{CpusetCpus: cpuset}})
+	return errors.Wrap(err, "ContainerUpdate")
 }
 
-func sched(job *bencher.Job) error {
+// sched queues the given jobs at once, so the ones scheduled meanwhile can't get in between them (e.g: ab rounds)
+func sched(jobs []*bencher.Job) error {
 	db, err := initDB()
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 	return db.Update(func(tx *bbolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists(bencher.KeySched)
-		if err != nil {
-			return fmt.Errorf("create bucket: %s", err)
+		for _, j := range jobs {
+			err := bencher.Enqueue(tx, j)
+			if err != nil {
+				return err
+			}
 		}
-		return b.Put(bencher.KeySched, append(b.Get(bencher.KeySched), []byte(job.Version+",")...))
+		return nil
 	})
 }
 
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	"github.com/schattian/bencher/internal/bencher"
+)
+
+const (
+	quietPollInterval = 2 * time.Second
+	quietMaxWait      = 30 * time.Minute // the job is started anyway once exceeded, so the queue goes on
+)
+
+// waitQuiet waits until the host load and the cpu usage of the containers not run by bencher stay under the
+// thresholds of the job for its quiet period. The last measured load is recorded on the job, only the load
+// average when the gate is disabled
+func waitQuiet(ctx context.Context, docker *client.Client, j *bencher.Job) error {
+	if j.Quiet.For <= 0 { // just a cheap sample, the stats of the containers take a while
+		load, err := loadAvg()
+		if err != nil {
+			return errors.Wrap(err, "loadAvg")
+		}
+		j.HostLoad = &bencher.HostLoad{Load: load}
+		return nil
+	}
+	err := os.WriteFile(bencher.ServerQuietFilename, []byte(j.Version), 0644)
+	if err != nil {
+		return errors.Wrap(err, "WriteFile")
+	}
+	defer os.Remove(bencher.ServerQuietFilename)
+	start := time.Now()
+	var quietSince time.Time
+	for {
+		load, err := loadAvg()
+		if err != nil {
+			return errors.Wrap(err, "loadAvg")
+		}
+		cpu, err := othersCPU(ctx, docker)
+		if err != nil {
+			return errors.Wrap(err, "othersCPU")
+		}
+		now := time.Now()
+		j.HostLoad = &bencher.HostLoad{Load: load, OthersCPU: &cpu, Waited: now.Sub(start).Round(time.Second)}
+		if load > j.Quiet.Load || cpu > j.Quiet.CPU {
+			quietSince = time.Time{}
+		} else if quietSince.IsZero() {
+			quietSince = now
+		}
+		if !quietSince.IsZero() && now.Sub(quietSince) >= j.Quiet.For {
+			return nil
+		}
+		if now.Sub(start) >= quietMaxWait {
+			log.Printf("host not quiet after %s (%s), running %s anyway", quietMaxWait, j.HostLoad, j.Version)
+			return nil
+		}
+		time.Sleep(quietPollInterval)
+	}
+}
+
+// loadAvg returns the 1m load average of the host, which isn't namespaced by the container
+func loadAvg() (float64, error) {
+	b, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return 0, errors.New("empty /proc/loadavg")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// othersCPU returns the percent of the host cpu used by the running containers not run by bencher
+func othersCPU(ctx context.Context, docker *client.Client) (float64, error) {
+	containers, err := docker.ContainerList(ctx, types.ContainerListOptions{})
+	if err != nil {
+		return 0, errors.Wrap(err, "ContainerList")
+	}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		total    float64
+		firstErr error
+	)
+	for _, c := range containers {
+		if _, ok := c.Labels[bencher.ContainersLabel]; ok {
+			continue
+		}
+		wg.Add(1)
+		go func(id string) { // each sample blocks for about a second
+			defer wg.Done()
+			cpu, err := containerCPU(ctx, docker, id)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			total += cpu
+		}(c.ID)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return 0, firstErr
+	}
+	return total, nil
+}
+
+// containerCPU returns the percent of the host cpu used by the given container
+func containerCPU(ctx context.Context, docker *client.Client, id string) (float64, error) {
+	stats, err := docker.ContainerStats(ctx, id, false) // primed, so the previous sample is given too
+	if err != nil {
+		return 0, errors.Wrapf(err, "ContainerStats[%s]", id)
+	}
+	defer stats.Body.Close()
+	var s types.StatsJSON
+	err = json.NewDecoder(stats.Body).Decode(&s)
+	if err != nil {
+		return 0, errors.Wrapf(err, "decode[%s]", id)
+	}
+	cpuDelta := float64(s.CPUStats.CPUUsage.TotalUsage) - float64(s.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(s.CPUStats.SystemUsage) - float64(s.PreCPUStats.SystemUsage)
+	if cpuDelta > 0 && systemDelta > 0 {
+		return cpuDelta / systemDelta * 100, nil
+	}
+	return 0, nil
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"log"
 
@@ -21,8 +20,20 @@ func (cmd *schedCmd) Run(args []string) int {
 	if len(args) == 0 {
 		return 128
 	}
-	j := &bencher.Job{Version: args[0]}
-	err := run(context.Background(), j)
+	// versions are queued in the given order, and run along with the rest of the queue unless it's already being run
+	var jobs []*bencher.Job
+	for _, version := range args {
+		j, err := getJob(version)
+		if err != nil {
+			log.Fatal(err)
+		}
+		jobs = append(jobs, j)
+	}
+	err := sched(jobs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = runQueue(context.Background())
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -36,17 +47,10 @@ func lookupNext() (*bencher.Job, error) {
 		return nil, err
 	}
 	defer db.Close()
-	err = db.Update(func(tx *bbolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists(bencher.KeySched)
-		if err != nil {
-			return errors.Wrap(err, "CreateBucket")
-		}
-		v := b.Get(bencher.KeySched)
-		i := bytes.IndexByte(v, ',')
-		if i <= 0 {
-			return nil
+	err = db.View(func(tx *bbolt.Tx) error {
+		if queue := bencher.Queue(tx); len(queue) > 0 {
+			nextVersion = queue[0]
 		}
-		nextVersion = string(v[:i])
 		return nil
 	})
 	if err != nil {
@@ -55,20 +59,40 @@ func lookupNext() (*bencher.Job, error) {
 	if nextVersion == "" {
 		return nil, nil
 	}
-	j := &bencher.Job{Version: nextVersion}
+	return getJob(nextVersion)
+}
+
+func getJob(version string) (*bencher.Job, error) {
+	db, err := initDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	j, err := bencher.GetPending(db, version)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetPending")
+	}
 	return j, nil
 }
 
-func unsched(version string) error {
+// unsched removes the version from the queue, reporting whether it was queued
+func unsched(version string) (found bool, err error) {
 	db, err := initDB()
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer db.Close()
-	return db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(bencher.KeySched)
-		return b.Put(bencher.KeySched, b.Get(bencher.KeySched)[len(version)+1:])
+	err = db.Update(func(tx *bbolt.Tx) error {
+		queue := bencher.Queue(tx)
+		for i, v := range queue {
+			if v == version {
+				found = true
+				return bencher.PutQueue(tx, append(queue[:i], queue[i+1:]...))
+			}
+		}
+		return nil
 	})
+	return found, err
 }
 
 func (cmd *schedCmd) Synopsis() string {
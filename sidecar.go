@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	"github.com/schattian/bencher/internal/bencher"
+)
+
+var sidecarName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// parseSidecars parses the sidecars given as --sidecar NAME=IMAGE, configured by --sidecar-env NAME:KEY=VAL
+// and --sidecar-health NAME:CMD
+func parseSidecars(specs, envs, healthchecks []string) ([]bencher.Sidecar, error) {
+	var sidecars []bencher.Sidecar
+	for _, spec := range specs {
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 || !sidecarName.MatchString(kv[0]) || kv[1] == "" {
+			return nil, fmt.Errorf("invalid --sidecar value %q, must be NAME=IMAGE", spec)
+		}
+		sidecars = append(sidecars, bencher.Sidecar{Name: kv[0], Image: kv[1]})
+	}
+	byName := map[string]*bencher.Sidecar{}
+	for i := range sidecars {
+		if byName[sidecars[i].Name] != nil {
+			return nil, fmt.Errorf("sidecar %s given twice", sidecars[i].Name)
+		}
+		byName[sidecars[i].Name] = &sidecars[i]
+	}
+
+	for _, v := range envs {
+		kv := strings.SplitN(v, ":", 2)
+		if len(kv) != 2 || byName[kv[0]] == nil || !strings.Contains(kv[1], "=") {
+			return nil, fmt.Errorf("invalid --sidecar-env value %q, must be NAME:KEY=VAL of a given --sidecar", v)
+		}
+		byName[kv[0]].Env = append(byName[kv[0]].Env, expandEnv(kv[1]))
+	}
+	for _, v := range healthchecks {
+		kv := strings.SplitN(v, ":", 2)
+		if len(kv) != 2 || byName[kv[0]] == nil || kv[1] == "" {
+			return nil, fmt.Errorf("invalid --sidecar-health value %q, must be NAME:CMD of a given --sidecar", v)
+		}
+		byName[kv[0]].Healthcheck = kv[1]
+	}
+	return sidecars, nil
+}
+
+func sidecarImages(sidecars []bencher.Sidecar) []string {
+	var images []string
+	for _, sc := range sidecars {
+		images = append(images, sc.Image)
+	}
+	return images
+}
+
+// createSidecars creates the private network of the job and its sidecars on it, to be started by the server
+func createSidecars(ctx context.Context, docker *client.Client, j *bencher.Job) error {
+	netName := bencher.NetworkName(j.Version)
+	labels := map[string]string{bencher.ContainersLabel: "sidecar", bencher.VersionLabel: j.Version}
+	_, err := docker.NetworkCreate(ctx, netName, types.NetworkCreate{
+		CheckDuplicate: true,
+		Internal:       true, // nothing but the sidecars is reachable
+		Labels:         labels,
+	})
+	if err != nil {
+		return errors.Wrap(err, "NetworkCreate")
+	}
+	err = createSidecarContainers(ctx, docker, j, labels)
+	if err != nil {
+		bencher.RemoveSidecars(ctx, docker, j.Version) // along with the network, so the version can be created again
+		return err
+	}
+	return nil
+}
+
+func createSidecarContainers(ctx context.Context, docker *client.Client, j *bencher.Job, labels map[string]string) error {
+	netName := bencher.NetworkName(j.Version)
+	j.Sidecars = append([]bencher.Sidecar{}, j.Sidecars...) // the variants of a matrix share them
+	for i := range j.Sidecars {
+		sc := &j.Sidecars[i]
+		var err error
+		sc.Addr, err = sidecarAddr(ctx, docker, sc)
+		if err != nil {
+			return errors.Wrapf(err, "sidecarAddr[%s]", sc.Name)
+		}
+		config := &container.Config{Image: sc.Image, Env: sc.Env, Labels: labels}
+		if sc.Healthcheck != "" {
+			config.Healthcheck = &container.HealthConfig{
+				Test:        []string{"CMD-SHELL", sc.Healthcheck},
+				Interval:    time.Second,
+				StartPeriod: time.Minute,
+			}
+		}
+		_, err = docker.ContainerCreate(
+			ctx,
+			config,
+			&container.HostConfig{NetworkMode: container.NetworkMode(netName)},
+			&network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{
+				netName: {Aliases: []string{sc.Name}},
+			}},
+			nil,
+			bencher.SidecarContainerName(j.Version, sc.Name),
+		)
+		if err != nil {
+			return errors.Wrapf(err, "ContainerCreate[%s]", sc.Name)
+		}
+	}
+	return nil
+}
+
+// sidecarsEnv gives the addresses of the sidecars to the runner
+func sidecarsEnv(sidecars []bencher.Sidecar) []string {
+	var env []string
+	for _, sc := range sidecars {
+		key := strings.ToUpper(strings.ReplaceAll(sc.Name, "-", "_"))
+		env = append(env, fmt.Sprintf("%s_HOST=%s", key, sc.Name), fmt.Sprintf("%s_ADDR=%s", key, sc.Addr))
+	}
+	return env
+}
+
+// sidecarAddr is the address of the sidecar on the network of the job, on the lowest port exposed by its image
+func sidecarAddr(ctx context.Context, docker *client.Client, sc *bencher.Sidecar) (string, error) {
+	info, _, err := docker.ImageInspectWithRaw(ctx, sc.Image)
+	if err != nil {
+		return "", err
+	}
+	if info.Config == nil || len(info.Config.ExposedPorts) == 0 {
+		return sc.Name, nil
+	}
+	var ports []int
+	for port := range info.Config.ExposedPorts {
+		ports = append(ports, port.Int())
+	}
+	sort.Ints(ports)
+	return fmt.Sprintf("%s:%d", sc.Name, ports[0]), nil
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/pkg/errors"
+)
+
+const defaultSnapshotLimit = 1 << 30 // 1GiB
+
+var ignoreFilenames = []string{".gitignore", ".bencherignore"}
+
+// ignoreRule is a pattern of an ignore file, following the .gitignore format
+type ignoreRule struct {
+	base     string // dir of the ignore file, patterns are relative to it
+	segs     []string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+func parseIgnoreRule(base, line string) (rule ignoreRule, ok bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false
+	}
+	rule.base = base
+	if strings.HasPrefix(line, "!") {
+		rule.negate, line = true, line[1:]
+	}
+	line = strings.TrimPrefix(line, `\`) // escaped # or !
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly, line = true, strings.TrimRight(line, "/")
+	}
+	rule.anchored = strings.Contains(line, "/")
+	rule.segs = strings.Split(strings.TrimPrefix(line, "/"), "/")
+	return rule, line != ""
+}
+
+func readIgnoreFile(fsys snapshotFS, dir, filename string) ([]ignoreRule, error) {
+	b, err := fsys.ReadFile(filepath.Join(dir, filename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rules []ignoreRule
+	for _, line := range strings.Split(string(b), "\n") {
+		if rule, ok := parseIgnoreRule(filepath.ToSlash(dir), line); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+func (r ignoreRule) match(absPath string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	rel := strings.TrimPrefix(absPath, r.base+"/")
+	if rel == absPath {
+		return false
+	}
+	segs := strings.Split(rel, "/")
+	if !r.anchored {
+		ok, _ := path.Match(r.segs[0], segs[len(segs)-1])
+		return ok
+	}
+	return matchSegs(r.segs, segs)
+}
+
+// mayMatchUnder reports whether the rule could match a path inside the given dir
+func (r ignoreRule) mayMatchUnder(absDir string) bool {
+	if !r.anchored {
+		return true
+	}
+	rel := strings.TrimPrefix(absDir, r.base+"/")
+	if rel == absDir {
+		return false
+	}
+	for i, seg := range strings.Split(rel, "/") {
+		if i >= len(r.segs) {
+			return false
+		}
+		if r.segs[i] == "**" {
+			return true
+		}
+		if ok, _ := path.Match(r.segs[i], seg); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func matchSegs(pattern, segs []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			if len(pattern) == 1 {
+				return len(segs) > 0
+			}
+			for i := range segs {
+				if matchSegs(pattern[1:], segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segs[0]); !ok {
+			return false
+		}
+		pattern, segs = pattern[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
+func isIgnored(rules []ignoreRule, absPath string, isDir bool) (ignored bool) {
+	for _, rule := range rules { // the last matching rule wins
+		if rule.match(absPath, isDir) {
+			ignored = !rule.negate
+		}
+	}
+	return
+}
+
+type snapshotEntry struct {
+	path string // relative to the snapshot root, slash separated
+	mode fs.FileMode
+	size int64
+}
+
+type walkState int
+
+const (
+	walkNormal   walkState = iota
+	walkIgnored            // only forced paths are taken
+	walkIncluded           // everything is taken
+)
+
+// snapshotFS is the file system the files of a snapshot are listed from
+type snapshotFS interface {
+	ReadDir(name string) ([]fs.DirEntry, error)
+	ReadFile(name string) ([]byte, error)
+	Lstat(name string) (fs.FileInfo, error)
+}
+
+type osFS struct{}
+
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+func (osFS) ReadFile(name string) ([]byte, error)       { return os.ReadFile(name) }
+func (osFS) Lstat(name string) (fs.FileInfo, error)     { return os.Lstat(name) }
+
+// snapshotter lists the files of a module honouring its ignore files, and copies them to a version
+type snapshotter struct {
+	fsys     snapshotFS // only the listing is done through it, the files are stored from the os
+	root     string
+	toplevel string       // of the git repository, found by its .git dir if not given (e.g: commits have none)
+	includes []ignoreRule // force the inclusion of ignored paths
+	ignores  []ignoreRule // added to the ones of the ignore files
+	entries  []snapshotEntry
+	size     int64
+}
+
+func newSnapshotter(root string, includes []string) *snapshotter {
+	s := &snapshotter{fsys: osFS{}, root: filepath.ToSlash(root)}
+	for _, include := range includes {
+		if rule, ok := parseIgnoreRule(s.root, "/"+strings.TrimPrefix(include, "/")); ok {
+			s.includes = append(s.includes, rule)
+		}
+	}
+	return s
+}
+
+// ignore adds the given patterns as ignore rules relative to base, as if they were on an ignore file of it
+func (s *snapshotter) ignore(base string, patterns []string) {
+	for _, pattern := range patterns {
+		if rule, ok := parseIgnoreRule(filepath.ToSlash(base), pattern); ok {
+			s.ignores = append(s.ignores, rule)
+		}
+	}
+}
+
+// list lists the files of the given dirs of the root, or all of them if none given
+func (s *snapshotter) list(dirs ...string) error {
+	if len(dirs) == 0 {
+		dirs = []string{s.root}
+	}
+	for _, dir := range dirs {
+		dir = filepath.ToSlash(dir)
+		err := s.walk(dir, append(s.parentRules(dir), s.ignores...), walkNormal)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listFile adds the given file of the root, if it exists
+func (s *snapshotter) listFile(p string) error {
+	info, err := s.fsys.Lstat(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.entries = append(s.entries, snapshotEntry{path: strings.TrimPrefix(filepath.ToSlash(p), s.root+"/"), mode: info.Mode(), size: info.Size()})
+	s.size += info.Size()
+	return nil
+}
+
+// parentRules reads the ignore files from the root of the git repository down to the given dir
+func (s *snapshotter) parentRules(root string) []ignoreRule {
+	var dirs []string
+	for dir := root; !s.isGitRoot(dir); {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil // not in a git repository
+		}
+		dir = parent
+		dirs = append([]string{dir}, dirs...)
+	}
+	var rules []ignoreRule
+	for _, dir := range dirs {
+		rules = append(rules, s.dirRules(dir)...)
+	}
+	return rules
+}
+
+func (s *snapshotter) isGitRoot(dir string) bool {
+	if s.toplevel != "" {
+		return dir == s.toplevel
+	}
+	_, err := s.fsys.Lstat(filepath.Join(dir, ".git"))
+	return err == nil
+}
+
+func (s *snapshotter) dirRules(dir string) (rules []ignoreRule) {
+	for _, filename := range ignoreFilenames {
+		r, err := readIgnoreFile(s.fsys, dir, filename)
+		if err != nil {
+			fmt.Printf("warning: couldn't read %s: %v\n", filepath.Join(dir, filename), err)
+			continue
+		}
+		rules = append(rules, r...)
+	}
+	return
+}
+
+func (s *snapshotter) walk(dir string, rules []ignoreRule, state walkState) error {
+	if state == walkNormal {
+		rules = append(rules[:len(rules):len(rules)], s.dirRules(dir)...)
+	}
+	entries, err := s.fsys.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Name() == ".git" {
+			continue
+		}
+		p := dir + "/" + e.Name()
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		isDir := info.IsDir()
+		taken := state == walkIncluded || isIncluded(s.includes, p, isDir) ||
+			(state == walkNormal && !isIgnored(rules, p, isDir))
+		if isDir {
+			switch {
+			case state == walkIncluded || isIncluded(s.includes, p, true):
+				err = s.walk(p, rules, walkIncluded)
+			case taken:
+				err = s.walk(p, rules, walkNormal)
+			case s.mayInclude(p):
+				err = s.walk(p, rules, walkIgnored)
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !taken {
+			continue
+		}
+		s.entries = append(s.entries, snapshotEntry{path: strings.TrimPrefix(p, s.root+"/"), mode: info.Mode(), size: info.Size()})
+		s.size += info.Size()
+	}
+	return nil
+}
+
+func isIncluded(includes []ignoreRule, absPath string, isDir bool) bool {
+	for _, include := range includes {
+		if include.match(absPath, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *snapshotter) mayInclude(absDir string) bool {
+	for _, include := range s.includes {
+		if include.mayMatchUnder(absDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// store adds the listed entries to the blobs, returning their manifest and the number of bytes that weren't stored yet
+func (s *snapshotter) store() (m *manifest, added int64, err error) {
+	m = &manifest{}
+	for _, e := range s.entries {
+		f := manifestFile{Path: e.path, Mode: e.mode, Size: e.size}
+		src := filepath.Join(s.root, e.path)
+		switch {
+		case e.mode&fs.ModeSymlink != 0:
+			f.Link, err = os.Readlink(src)
+			if err != nil {
+				return nil, 0, err
+			}
+		case e.mode.IsRegular():
+			var n int64
+			f.Hash, n, err = storeFile(src, e.mode)
+			if err != nil {
+				return nil, 0, errors.Wrapf(err, "storeFile[%s]", e.path)
+			}
+			added += n
+		default:
+			continue
+		}
+		m.Files = append(m.Files, f)
+	}
+	return m, added, nil
+}
+
+// copyTo copies the listed entries to dst
+func (s *snapshotter) copyTo(dst string) error {
+	for _, e := range s.entries {
+		src, target := filepath.Join(s.root, e.path), filepath.Join(dst, e.path)
+		err := os.MkdirAll(filepath.Dir(target), os.ModePerm)
+		if err != nil {
+			return err
+		}
+		if e.mode&fs.ModeSymlink != 0 {
+			link, err := os.Readlink(src)
+			if err != nil {
+				return err
+			}
+			err = os.Symlink(link, target)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !e.mode.IsRegular() {
+			continue
+		}
+		err = copyFile(src, target, e.mode)
+		if err != nil {
+			return errors.Wrapf(err, "copyFile[%s]", e.path)
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string, mode fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode.Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func (s *snapshotter) report(limit, added int64) {
+	fmt.Printf("snapshot of %d files, %s (%s new in the store)\n", len(s.entries), units.BytesSize(float64(s.size)), units.BytesSize(float64(added)))
+	if limit > 0 && s.size > limit {
+		fmt.Printf("warning: the snapshot exceeds the limit of %s, consider ignoring the unneeded files on a .bencherignore\n", units.BytesSize(float64(limit)))
+	}
+}
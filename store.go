@@ -0,0 +1,255 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/schattian/bencher/internal/bencher"
+)
+
+// manifest lists the files of a version, whose contents are kept as blobs named by their hash so they're
+// shared across versions
+type manifest struct {
+	Files []manifestFile
+}
+
+type manifestFile struct {
+	Path     string
+	Mode     fs.FileMode
+	Size     int64
+	Hash     string `json:",omitempty"` // content of regular files
+	Link     string `json:",omitempty"` // target of symlinks
+	Vendored bool   `json:",omitempty"` // created by go mod vendor, so it isn't restored
+}
+
+func (m *manifest) size() (size int64) {
+	for _, f := range m.Files {
+		size += f.Size
+	}
+	return
+}
+
+func manifestFilename(version string) string {
+	return filepath.Join(bencher.HostManifestsPath, version+".json")
+}
+
+func readManifest(version string) (*manifest, error) {
+	b, err := os.ReadFile(manifestFilename(version))
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{}
+	return m, json.Unmarshal(b, m)
+}
+
+func writeManifest(version string, m *manifest) error {
+	err := os.MkdirAll(bencher.HostManifestsPath, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(manifestFilename(version), b, 0644)
+}
+
+// blobFilename shards the blobs by the first byte of their hash. Executables are kept apart
+// since the hardlinks share their mode
+func blobFilename(hash string, mode fs.FileMode) string {
+	name := hash[2:]
+	if mode&0111 != 0 {
+		name += "x"
+	}
+	return filepath.Join(bencher.HostBlobsPath, hash[:2], name)
+}
+
+func blobMode(mode fs.FileMode) fs.FileMode {
+	if mode&0111 != 0 {
+		return 0555
+	}
+	return 0444
+}
+
+// storeFile adds the content of the given file to the blobs, returning the number of bytes that weren't stored yet
+func storeFile(src string, mode fs.FileMode) (hash string, added int64, err error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	hash = fmt.Sprintf("%x", h.Sum(nil))
+	blob := blobFilename(hash, mode)
+	if blobIntact(blob, hash) {
+		return hash, 0, nil
+	}
+	err = os.MkdirAll(filepath.Dir(blob), os.ModePerm)
+	if err != nil {
+		return "", 0, err
+	}
+	tmp := fmt.Sprintf("%s.%d.tmp", blob, os.Getpid())
+	err = copyFile(src, tmp, blobMode(mode))
+	if err != nil {
+		return "", 0, errors.Wrap(err, "copyFile")
+	}
+	info, err := os.Stat(tmp)
+	if err != nil {
+		return "", 0, err
+	}
+	return hash, info.Size(), os.Rename(tmp, blob)
+}
+
+// blobIntact reports whether the blob exists and still has the content of its hash, as it could've been written
+// through one of its hardlinks
+func blobIntact(blob, hash string) bool {
+	f, err := os.Open(blob)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	return err == nil && fmt.Sprintf("%x", h.Sum(nil)) == hash
+}
+
+// materialize creates the files of the manifest on dst. The blobs are hardlinked when link is given,
+// so dst must be mounted as read-only
+func materialize(m *manifest, dst string, link bool, skipVendored bool) error {
+	for _, f := range m.Files {
+		if skipVendored && f.Vendored {
+			continue
+		}
+		target := filepath.Join(dst, filepath.FromSlash(f.Path))
+		err := os.MkdirAll(filepath.Dir(target), os.ModePerm)
+		if err != nil {
+			return err
+		}
+		// an existing target may be a hardlink to a blob, which must never be written through
+		err = os.Remove(target)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if f.Mode&fs.ModeSymlink != 0 {
+			err = os.Symlink(f.Link, target)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		blob := blobFilename(f.Hash, f.Mode)
+		if link {
+			err = os.Link(blob, target)
+			if err == nil {
+				continue
+			}
+			// e.g: the versions are on a different device than the blobs
+		}
+		err = copyFile(blob, target, f.Mode)
+		if err != nil {
+			return errors.Wrapf(err, "copyFile[%s]", f.Path)
+		}
+	}
+	return nil
+}
+
+// storeVendor moves the vendor dir of the version created by go mod vendor into the blobs, replacing its files by
+// hardlinks if link is set
+func storeVendor(versionPath, vendorPath string, link bool) (files []manifestFile, added int64, err error) {
+	err = filepath.WalkDir(vendorPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(versionPath, p)
+		if err != nil {
+			return err
+		}
+		f := manifestFile{Path: filepath.ToSlash(rel), Mode: info.Mode(), Size: info.Size(), Vendored: true}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			f.Link, err = os.Readlink(p)
+			files = append(files, f)
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		var n int64
+		f.Hash, n, err = storeFile(p, info.Mode())
+		if err != nil {
+			return errors.Wrapf(err, "storeFile[%s]", f.Path)
+		}
+		added += n
+		files = append(files, f)
+		if !link {
+			return nil
+		}
+		err = os.Remove(p)
+		if err != nil {
+			return err
+		}
+		err = os.Link(blobFilename(f.Hash, f.Mode), p)
+		if err != nil {
+			return copyFile(blobFilename(f.Hash, f.Mode), p, f.Mode)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
+
+// gcBlobs removes the blobs that aren't referenced by any manifest
+func gcBlobs() error {
+	referenced := make(map[string]bool)
+	manifests, err := os.ReadDir(bencher.HostManifestsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, e := range manifests {
+		m, err := readManifest(strings.TrimSuffix(e.Name(), ".json"))
+		if err != nil {
+			return errors.Wrapf(err, "readManifest[%s]", e.Name())
+		}
+		for _, f := range m.Files {
+			if f.Hash != "" {
+				referenced[blobFilename(f.Hash, f.Mode)] = true
+			}
+		}
+	}
+	err = filepath.WalkDir(bencher.HostBlobsPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || referenced[p] {
+			return err
+		}
+		return os.Remove(p)
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func rmManifests(versions ...string) error {
+	for _, version := range versions {
+		err := os.Remove(manifestFilename(version))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
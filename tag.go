@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mitchellh/cli"
+	"github.com/pkg/errors"
+	"github.com/schattian/bencher/internal/bencher"
+	"go.etcd.io/bbolt"
+)
+
+var tagName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_./-]*$`)
+
+// parseTags validates the given tags, dropping the repeated ones
+func parseTags(tags []string) ([]string, error) {
+	var parsed []string
+	for _, tag := range tags {
+		if !tagName.MatchString(tag) {
+			return nil, fmt.Errorf("invalid tag %q, must be alphanumeric (also _ . / -)", tag)
+		}
+		if !isInStrSl(tag, parsed) {
+			parsed = append(parsed, tag)
+		}
+	}
+	return parsed, nil
+}
+
+// selectTagged returns the given versions tagged with all the tags, or every version tagged with them if none given.
+// The pending versions are only taken if withPending
+func selectTagged(db *bbolt.DB, tags, versions []string, withPending bool) ([]string, error) {
+	keys := [][]byte{bencher.KeyJob}
+	if withPending {
+		keys = append(keys, bencher.KeyPending)
+	}
+	var tagged []string
+	err := db.View(func(tx *bbolt.Tx) error {
+		for _, key := range keys {
+			b := tx.Bucket(key)
+			if b == nil {
+				continue
+			}
+			err := b.ForEach(func(version, jobBytes []byte) error {
+				j := &bencher.Job{}
+				err := json.Unmarshal(jobBytes, j)
+				if err != nil {
+					return err
+				}
+				if j.HasTags(tags) && !isInStrSl(j.Version, tagged) {
+					tagged = append(tagged, j.Version)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil || len(versions) == 0 {
+		return tagged, err
+	}
+	var selected []string
+	for _, version := range versions { // keep the given order
+		if isInStrSl(version, tagged) {
+			selected = append(selected, version)
+		}
+	}
+	return selected, nil
+}
+
+// updateJobs applies fn to the given version, along with its variants if it's a matrix
+func updateJobs(version string, fn func(*bencher.Job)) error {
+	db, err := initDB()
+	if err != nil {
+		return errors.Wrap(err, "initDB")
+	}
+	defer db.Close()
+	versions, err := withVariants(db, []string{version})
+	if err != nil {
+		return errors.Wrap(err, "withVariants")
+	}
+	var updated bool
+	for _, v := range versions {
+		found, err := bencher.UpdateJob(db, v, fn)
+		if err != nil {
+			return errors.Wrapf(err, "UpdateJob[%s]", v)
+		}
+		updated = updated || found
+	}
+	if !updated {
+		return fmt.Errorf("job %s not found", version)
+	}
+	return nil
+}
+
+type tagCmd struct{}
+
+func prepareTag() (cli.Command, error) {
+	return &tagCmd{}, nil
+}
+
+func (cmd *tagCmd) Run(args []string) int {
+	fs := newFlagSet("tag")
+	del := fs.Bool("d", false, "")
+	args, ok := parseInterspersed(fs, args)
+	if !ok || len(args) < 2 {
+		return cli.RunResultHelp
+	}
+	tags, err := parseTags(args[1:])
+	if err != nil {
+		fmt.Printf("err %v", err)
+		return 1
+	}
+	err = updateJobs(args[0], func(j *bencher.Job) {
+		if *del {
+			j.Tags = diffStrSl(j.Tags, tags)
+			return
+		}
+		j.Tags, _ = parseTags(append(j.Tags, tags...))
+	})
+	if err != nil {
+		fmt.Printf("err %v", err)
+		return 1
+	}
+	return 0
+}
+
+func (cmd *tagCmd) Synopsis() string {
+	return `add or remove tags of a version`
+}
+
+func (cmd *tagCmd) Help() string {
+	return `Usage: bencher tag [-d] <version> <tag1> [tag2] [...]
+
+Add the tags to the version (either completed or scheduled), or remove them if [-d] is given. The variants of a matrix are tagged along with it.
+Tags can also be given on "bencher run --tag", and used to filter the versions of "bencher get", "bencher cmp" and "bencher rm" (e.g: bencher cmp --tag parser)`
+}
+
+type noteCmd struct{}
+
+func prepareNote() (cli.Command, error) {
+	return &noteCmd{}, nil
+}
+
+func (cmd *noteCmd) Run(args []string) int {
+	fs := newFlagSet("note")
+	args, ok := parseInterspersed(fs, args)
+	if !ok || len(args) == 0 {
+		return cli.RunResultHelp
+	}
+	note := strings.Join(args[1:], " ")
+	err := updateJobs(args[0], func(j *bencher.Job) { j.Note = note })
+	if err != nil {
+		fmt.Printf("err %v", err)
+		return 1
+	}
+	return 0
+}
+
+func (cmd *noteCmd) Synopsis() string {
+	return `set the note of a version`
+}
+
+func (cmd *noteCmd) Help() string {
+	return `Usage: bencher note <version> [note]
+
+Set the note of the version (either completed or scheduled), replacing the one given on "bencher run --note". The note is removed if none given.
+The variants of a matrix are given the note along with it`
+}
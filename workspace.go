@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// moduleLayout are the local modules a module builds with: the ones used by its go.work and the ones replaced by
+// local paths. They're snapshotted together, keeping their layout relative to base
+type moduleLayout struct {
+	base  string   // closest dir containing all of them
+	root  string   // dir of the module being run
+	dirs  []string // dirs to snapshot, none of them inside another
+	work  string   // go.work file, if any
+	files []string // files to snapshot apart from the dirs (e.g: go.work)
+}
+
+// findLayout finds the layout of the module on root, reading its go.mod and go.work files through readFile
+// so they can be read as of a commit
+func findLayout(root string, readFile func(string) ([]byte, error)) (*moduleLayout, error) {
+	l := &moduleLayout{base: root, root: root}
+	modDirs := []string{root} // out of workspace mode, only the replaces of the main module apply
+	var replaces []string
+	work, err := goWork(root)
+	if err != nil {
+		return nil, err
+	}
+	if work != "" {
+		b, err := readFile(work)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			l.work = work
+			l.files = append(l.files, work)
+			if _, err := readFile(work + ".sum"); err == nil {
+				l.files = append(l.files, work+".sum")
+			}
+			workDir := filepath.Dir(work)
+			for _, use := range parseDirectives(b, "use") {
+				modDirs = append(modDirs, localPath(workDir, use[0]))
+			}
+			replaces = append(replaces, localReplaces(workDir, b)...)
+		}
+	}
+	for _, dir := range modDirs {
+		b, err := readFile(filepath.Join(dir, "go.mod"))
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %v", dir, err)
+		}
+		replaces = append(replaces, localReplaces(dir, b)...)
+	}
+
+	for _, dir := range append(modDirs, replaces...) {
+		l.addDir(dir)
+	}
+	for _, p := range append(l.dirs, l.files...) {
+		for !isUnder(p, l.base) {
+			l.base = filepath.Dir(l.base)
+		}
+	}
+	return l, nil
+}
+
+// goWork returns the go.work file the module on root is built with, if any
+func goWork(root string) (string, error) {
+	execCmd := exec.Command("go", "env", "GOWORK")
+	execCmd.Dir = root
+	var stderr bytes.Buffer
+	execCmd.Stderr = &stderr
+	out, err := execCmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("go env GOWORK: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	work := strings.TrimSpace(string(out))
+	if work == "off" {
+		return "", nil
+	}
+	return work, nil
+}
+
+// addDir adds the dir to snapshot, unless it's inside one of the already added ones
+func (l *moduleLayout) addDir(dir string) {
+	var dirs []string
+	for _, d := range l.dirs {
+		if isUnder(dir, d) {
+			return
+		}
+		if !isUnder(d, dir) {
+			dirs = append(dirs, d)
+		}
+	}
+	l.dirs = append(dirs, dir)
+}
+
+// rel returns the given path relative to the base, slash separated
+func (l *moduleLayout) rel(p string) string {
+	rel, _ := filepath.Rel(l.base, p)
+	return filepath.ToSlash(rel)
+}
+
+// workingDir returns the given dir relative to the base, as the working dir of the runner
+func (l *moduleLayout) workingDir(wd string) string {
+	if rel := l.rel(wd); rel != "." {
+		return "/" + rel
+	}
+	return ""
+}
+
+// vendorDir is the dir where the dependencies are vendored: the workspace one or the module one
+func (l *moduleLayout) vendorDir() string {
+	if l.work != "" {
+		return filepath.Dir(l.work)
+	}
+	return l.root
+}
+
+func isUnder(p, dir string) bool {
+	return p == dir || strings.HasPrefix(p, dir+string(os.PathSeparator)) || dir == string(os.PathSeparator)
+}
+
+// localReplaces returns the dirs of the replace directives pointing to local paths, relative to the given dir
+func localReplaces(dir string, b []byte) []string {
+	var dirs []string
+	for _, fields := range parseDirectives(b, "replace") {
+		for i, f := range fields {
+			if f != "=>" || i+1 >= len(fields) {
+				continue
+			}
+			target := fields[i+1]
+			if unquoted, err := strconv.Unquote(target); err == nil {
+				target = unquoted
+			}
+			if strings.HasPrefix(target, "./") || strings.HasPrefix(target, "../") || filepath.IsAbs(target) {
+				dirs = append(dirs, localPath(dir, target))
+			}
+		}
+	}
+	return dirs
+}
+
+func localPath(dir, p string) string {
+	if unquoted, err := strconv.Unquote(p); err == nil {
+		p = unquoted
+	}
+	if filepath.IsAbs(p) {
+		return filepath.Clean(p)
+	}
+	return filepath.Join(dir, filepath.FromSlash(p))
+}
+
+// parseDirectives returns the fields of each directive of the given verb on a go.mod or go.work file, either
+// given in a line or in a block
+func parseDirectives(b []byte, verb string) [][]string {
+	var directives [][]string
+	inBlock := false
+	for _, line := range strings.Split(string(b), "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if inBlock {
+			if fields[0] == ")" {
+				inBlock = false
+				continue
+			}
+			directives = append(directives, fields)
+			continue
+		}
+		if fields[0] != verb {
+			continue
+		}
+		if len(fields) == 2 && fields[1] == "(" {
+			inBlock = true
+			continue
+		}
+		if len(fields) > 1 {
+			directives = append(directives, fields[1:])
+		}
+	}
+	return directives
+}